@@ -0,0 +1,42 @@
+package datastax_astra
+
+import (
+	"context"
+
+	dsAstraClient "github.com/datastax/astra-client-go/v2/astra"
+)
+
+// AstraAPI is the part of the Astra DevOps API the backend depends on. The backend
+// talks to Astra only through this interface, so tests can point it at a fake such as
+// the one in the astratest package, or replace the client altogether.
+type AstraAPI interface {
+	// CreateToken mints a new token with the given role IDs
+	CreateToken(ctx context.Context, roles []string) (*GeneratedToken, error)
+	// ListTokens lists the client IDs of every token in the org
+	ListTokens(ctx context.Context) ([]ClientIdSecret, error)
+	// DeleteToken revokes the token with the given client ID. Deleting a token that no longer exists is not an error.
+	DeleteToken(ctx context.Context, clientId string) error
+	// GetCurrentOrg returns the org the client's token belongs to
+	GetCurrentOrg(ctx context.Context) (*dsAstraClient.Organization, error)
+	// ListRoles lists the default and custom roles of the org
+	ListRoles(ctx context.Context) ([]dsAstraClient.Role, error)
+	// CreateCustomRole creates a custom role with the given policy
+	CreateCustomRole(ctx context.Context, name string, policy dsAstraClient.Policy) (*dsAstraClient.Role, error)
+	// UpdateCustomRole replaces the name and policy of a custom role
+	UpdateCustomRole(ctx context.Context, roleId string, name string, policy dsAstraClient.Policy) error
+	// DeleteCustomRole deletes a custom role. Deleting a role that no longer exists is not an error.
+	DeleteCustomRole(ctx context.Context, roleId string) error
+}
+
+// GeneratedToken is the token returned by Astra when creating a token. Astra also
+// returns the time the token was generated, which the typed client does not model.
+type GeneratedToken struct {
+	dsAstraClient.GenerateTokenResponse
+	GeneratedOn string `json:"generatedOn"`
+}
+
+// ClientIdSecret is a client ID listed by Astra, along with the time it was generated.
+type ClientIdSecret struct {
+	dsAstraClient.ClientRole
+	GeneratedOn *string `json:"generatedOn,omitempty"`
+}
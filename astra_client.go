@@ -1,75 +1,281 @@
 package datastax_astra
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	neturl "net/url"
+	"time"
 
 	dsAstraClient "github.com/datastax/astra-client-go/v2/astra"
+	"github.com/hashicorp/go-cleanhttp"
 )
 
 const (
-	secretsPath   = "/v2/clientIdSecrets"
-	pluginversion = "Vault-Plugin v2.0.0"
+	pluginversion         = "Vault-Plugin v2.0.0"
+	defaultRequestTimeout = 30 * time.Second
+	// defaultCallTimeout bounds a whole call to the Astra API, including its retries
+	defaultCallTimeout = 2 * time.Minute
 )
 
 // astraClient creates an object storing
-// the client.
+// the client. It implements AstraAPI against the real Astra DevOps API.
 type astraClient struct {
 	*dsAstraClient.Client
-	url   string
-	token string
+	url         string
+	token       string
+	httpClient  *http.Client
+	retryPolicy retryPolicy
 }
 
-func makeHttpRequest(method, url, payload, astraToken string) (*http.Response, error) {
-	client := &http.Client{}
-	var body io.Reader
-	if payload != "" {
-		body = strings.NewReader(payload)
-	} else {
-		body = nil
+// newHttpClient builds the long-lived HTTP client used for every call to an org's Astra API
+// from the transport settings in its config.
+func newHttpClient(config *astraConfig) (*http.Client, error) {
+	transport := cleanhttp.DefaultPooledTransport()
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.TLSSkipVerify,
+	}
+	if config.TLSCACert != "" {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM([]byte(config.TLSCACert)) {
+			return nil, errors.New("unable to parse tls_ca_cert as a PEM encoded certificate bundle")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	if config.ProxyURL != "" {
+		proxyURL, err := neturl.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, errors.New("unable to parse proxy_url: " + err.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.MaxIdleConns > 0 {
+		transport.MaxIdleConns = config.MaxIdleConns
+	}
+	if config.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	if config.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.IdleConnTimeout
+	}
+	transport.DisableKeepAlives = config.DisableKeepAlives
+
+	timeout := defaultRequestTimeout
+	if config.RequestTimeout > 0 {
+		timeout = config.RequestTimeout
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}, nil
+}
+
+// Do sends a request built by the typed Astra client, retrying it according to the client's retry policy.
+func (ac *astraClient) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		attemptReq := req.Clone(ctx)
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+
+		res, err := ac.httpClient.Do(attemptReq)
+		retry, wait := ac.retryPolicy.shouldRetry(req.Method, attempt, res, err)
+		if !retry {
+			// A response that arrived just as the request was cancelled is still returned, since Astra
+			//  may have acted on it, for example by creating a token the caller has to clean up
+			if res == nil && ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return res, err
+		}
+		discardResponse(res)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// addHeaders authenticates each request against the Astra API
+func (ac *astraClient) addHeaders(ctx context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+ac.token)
+	req.Header.Set("User-Agent", pluginversion)
+	return nil
+}
+
+// decodeResponse checks the status of a response from the Astra API and decodes its body into result
+func decodeResponse(res *http.Response, result interface{}, expectedStatus ...int) error {
+	defer res.Body.Close()
+	for _, status := range expectedStatus {
+		if res.StatusCode == status {
+			if result == nil {
+				return nil
+			}
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				return errors.New("error reading ioutil " + err.Error())
+			}
+			err = json.Unmarshal(body, result)
+			if err != nil {
+				return fmt.Errorf("failed to decode JSON response '%s', caused by %w", string(body), err)
+			}
+			return nil
+		}
 	}
-	httpReq, err := http.NewRequest(method, url, body)
+	return newAstraAPIError(res)
+}
+
+func (ac *astraClient) CreateToken(ctx context.Context, roles []string) (*GeneratedToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultCallTimeout)
+	defer cancel()
+
+	res, err := ac.GenerateTokenForClient(ctx, dsAstraClient.GenerateTokenForClientJSONRequestBody{Roles: roles})
 	if err != nil {
-		return nil, errors.New("error creating httpReq " + err.Error())
+		return nil, fmt.Errorf("error sending request: %w", err)
 	}
 
-	httpReq.Header.Add("Content-Type", "application/json")
-	httpReq.Header.Add("Authorization", "Bearer "+astraToken)
-	httpReq.Header.Add("User-Agent", pluginversion)
-	return client.Do(httpReq)
+	var token GeneratedToken
+	err = decodeResponse(res, &token, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
 }
 
-func (ac *astraClient) createToken(payload string) ([]byte, error) {
-	url := ac.url + secretsPath
-	res, err := makeHttpRequest(http.MethodPost, url, payload, ac.token)
+func (ac *astraClient) ListTokens(ctx context.Context) ([]ClientIdSecret, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultCallTimeout)
+	defer cancel()
+
+	res, err := ac.GetClientsForOrg(ctx)
 	if err != nil {
-		return nil, errors.New("error sending request " + err.Error())
+		return nil, fmt.Errorf("error sending request: %w", err)
 	}
 
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
+	var clientList struct {
+		Clients []ClientIdSecret `json:"clients"`
+	}
+	err = decodeResponse(res, &clientList, http.StatusOK)
 	if err != nil {
-		return nil, errors.New("error reading ioutil " + err.Error())
+		return nil, err
 	}
 
-	return body, nil
+	return clientList.Clients, nil
 }
 
-func (ac *astraClient) deleteToken(clientId string) error {
-	url := ac.url + secretsPath + "/" + clientId
-	res, err := makeHttpRequest(http.MethodDelete, url, "", ac.token)
+func (ac *astraClient) GetCurrentOrg(ctx context.Context) (*dsAstraClient.Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultCallTimeout)
+	defer cancel()
+
+	res, err := ac.GetCurrentOrganization(ctx)
 	if err != nil {
-		return errors.New("error sending request " + err.Error())
+		return nil, fmt.Errorf("error sending request: %w", err)
 	}
 
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusNoContent {
-		return errors.New("unable to delete token in astra; " + res.Status)
+	var org dsAstraClient.Organization
+	err = decodeResponse(res, &org, http.StatusOK)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &org, nil
+}
+
+func (ac *astraClient) DeleteToken(ctx context.Context, clientId string) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultCallTimeout)
+	defer cancel()
+
+	res, err := ac.DeleteTokenForClient(ctx, dsAstraClient.ClientIdParam(clientId))
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+
+	// A retried delete may find the token already gone; there is nothing left to revoke in that case
+	return decodeResponse(res, nil, http.StatusNoContent, http.StatusNotFound)
+}
+
+func (ac *astraClient) ListRoles(ctx context.Context) ([]dsAstraClient.Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultCallTimeout)
+	defer cancel()
+
+	res, err := ac.GetOrganizationRoles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+
+	var roles []dsAstraClient.Role
+	err = decodeResponse(res, &roles, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (ac *astraClient) CreateCustomRole(ctx context.Context, name string, policy dsAstraClient.Policy) (*dsAstraClient.Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultCallTimeout)
+	defer cancel()
+
+	res, err := ac.AddOrganizationRole(ctx, dsAstraClient.AddOrganizationRoleJSONRequestBody{Name: name, Policy: policy})
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+
+	var role dsAstraClient.Role
+	err = decodeResponse(res, &role, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+func (ac *astraClient) UpdateCustomRole(ctx context.Context, roleId string, name string, policy dsAstraClient.Policy) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultCallTimeout)
+	defer cancel()
+
+	res, err := ac.Client.UpdateRole(ctx, dsAstraClient.RoleIdParam(roleId), dsAstraClient.UpdateRoleJSONRequestBody{Name: name, Policy: policy})
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+
+	return decodeResponse(res, nil, http.StatusOK, http.StatusNoContent)
+}
+
+func (ac *astraClient) DeleteCustomRole(ctx context.Context, roleId string) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultCallTimeout)
+	defer cancel()
+
+	res, err := ac.DeleteOrganizationRole(ctx, dsAstraClient.RoleIdParam(roleId))
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+
+	// A retried delete may find the role already gone
+	return decodeResponse(res, nil, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
 }
 
 // newClient creates a new client to access Astra
@@ -87,9 +293,46 @@ func newClient(config *astraConfig) (*astraClient, error) {
 		return nil, errors.New("client URL was not defined")
 	}
 
+	httpClient, err := newHttpClient(config)
+	if err != nil {
+		return nil, err
+	}
+
 	newAstraClient := astraClient{}
 	newAstraClient.url = config.URL
 	newAstraClient.token = config.AstraToken
+	newAstraClient.httpClient = httpClient
+	newAstraClient.retryPolicy = newRetryPolicy(config)
+	newAstraClient.Client, err = dsAstraClient.NewClient(
+		config.URL,
+		dsAstraClient.WithHTTPClient(&newAstraClient),
+		dsAstraClient.WithRequestEditorFn(newAstraClient.addHeaders))
+	if err != nil {
+		return nil, errors.New("error creating astra client: " + err.Error())
+	}
 
 	return &newAstraClient, nil
 }
+
+// newAstraAPI is the backend's default client factory, calling the real Astra API
+func newAstraAPI(config *astraConfig) (AstraAPI, error) {
+	client, err := newClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// verifyClient checks that the client's token authenticates against Astra
+// and that it belongs to the given organization.
+func verifyClient(ctx context.Context, c AstraAPI, orgId string) error {
+	org, err := c.GetCurrentOrg(ctx)
+	if err != nil {
+		return err
+	}
+	if org.Id != orgId {
+		return errors.New("astra token belongs to org ID " + org.Id + ", not " + orgId)
+	}
+
+	return nil
+}
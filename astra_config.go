@@ -1,21 +1,142 @@
 package datastax_astra
 
+import (
+    "encoding/json"
+    "math/rand"
+    neturl "net/url"
+    "time"
+)
+
 // astraConfig includes the minimum configuration
 // required to instantiate a new astra client.
 type astraConfig struct {
-    AstraToken  string     `json:"astra_token"`
-    URL         string     `json:"url"`
-    OrgId       string     `json:"org_id"`
-    LogicalName string     `json:"logical_name"`
-    CallerMode  CallerMode `json:"caller_mode"`
+    AstraToken     string        `json:"astra_token"`
+    URL            string        `json:"url"`
+    OrgId          string        `json:"org_id"`
+    LogicalName    string        `json:"logical_name"`
+    CallerMode     CallerMode    `json:"caller_mode"`
+    RotationPeriod time.Duration `json:"rotation_period"`
+    RotationWindow time.Duration `json:"rotation_window"`
+    LastRotated    time.Time     `json:"last_rotated"`
+    NextRotation   time.Time     `json:"next_rotation"`
+    // PendingRootClientIds are previous root tokens that could not be revoked when they were rotated
+    PendingRootClientIds []string `json:"pending_root_client_ids,omitempty"`
+
+    TLSCACert           string        `json:"tls_ca_cert"`
+    TLSSkipVerify       bool          `json:"tls_skip_verify"`
+    ProxyURL            string        `json:"proxy_url"`
+    RequestTimeout      time.Duration `json:"request_timeout"`
+    MaxIdleConns        int           `json:"max_idle_conns"`
+    MaxIdleConnsPerHost int           `json:"max_idle_conns_per_host"`
+    IdleConnTimeout     time.Duration `json:"idle_conn_timeout"`
+    DisableKeepAlives   bool          `json:"disable_keep_alives"`
+
+    MaxRetries   int           `json:"max_retries"`
+    RetryMinWait time.Duration `json:"retry_min_wait"`
+    RetryMaxWait time.Duration `json:"retry_max_wait"`
+
+    // The Tidy fields schedule reconciling the org's tokens in Vault with those in Astra; see pathTidy
+    TidyInterval            time.Duration `json:"tidy_interval"`
+    TidyDeleteStaleEntries  bool          `json:"tidy_delete_stale_entries"`
+    TidyRevokeUntracked     bool          `json:"tidy_revoke_untracked"`
+    TidyDeleteOrphanedRoles bool          `json:"tidy_delete_orphaned_roles"`
+}
+
+// UnmarshalJSON decodes a config, giving settings added since it was stored their defaults
+func (c *astraConfig) UnmarshalJSON(data []byte) error {
+    type config astraConfig
+    // Fields missing from the entry keep these values
+    entry := config{
+        MaxRetries: defaultMaxRetries,
+    }
+    err := json.Unmarshal(data, &entry)
+    if err != nil {
+        return err
+    }
+    *c = astraConfig(entry)
+    return nil
 }
 
+// ToResponseData returns the config without its astra_token and proxy credentials, which only ToRevealedResponseData includes.
+//  Durations are returned in seconds, as they are written.
 func (c *astraConfig) ToResponseData() map[string]interface{} {
     return map[string]interface{}{
-        "astra_token":  c.AstraToken,
-        "url":          c.URL,
-        "org_id":       c.OrgId,
-        "logical_name": c.LogicalName,
-        "caller_mode":  c.CallerMode.String(),
+        "url":             c.URL,
+        "org_id":          c.OrgId,
+        "logical_name":    c.LogicalName,
+        "caller_mode":     c.CallerMode.String(),
+        "rotation_period": int64(c.RotationPeriod.Seconds()),
+        "rotation_window": int64(c.RotationWindow.Seconds()),
+        "last_rotated":    formatTime(c.LastRotated),
+        "next_rotation":   formatTime(c.NextRotation),
+
+        "tls_ca_cert":             c.TLSCACert,
+        "tls_skip_verify":         c.TLSSkipVerify,
+        "proxy_url":               redactURL(c.ProxyURL),
+        "request_timeout":         int64(c.RequestTimeout.Seconds()),
+        "max_idle_conns":          c.MaxIdleConns,
+        "max_idle_conns_per_host": c.MaxIdleConnsPerHost,
+        "idle_conn_timeout":       int64(c.IdleConnTimeout.Seconds()),
+        "disable_keep_alives":     c.DisableKeepAlives,
+
+        "max_retries":    c.MaxRetries,
+        "retry_min_wait": int64(c.RetryMinWait.Seconds()),
+        "retry_max_wait": int64(c.RetryMaxWait.Seconds()),
+
+        "tidy_interval":              int64(c.TidyInterval.Seconds()),
+        "tidy_delete_stale_entries":  c.TidyDeleteStaleEntries,
+        "tidy_revoke_untracked":      c.TidyRevokeUntracked,
+        "tidy_delete_orphaned_roles": c.TidyDeleteOrphanedRoles,
+    }
+}
+
+func (c *astraConfig) ToRevealedResponseData() map[string]interface{} {
+    data := c.ToResponseData()
+    data["astra_token"] = c.AstraToken
+    data["proxy_url"] = c.ProxyURL
+    return data
+}
+
+// ToListData returns the metadata of the config shown when listing configs
+func (c *astraConfig) ToListData() map[string]interface{} {
+    return map[string]interface{}{
+        "url":           c.URL,
+        "org_id":        c.OrgId,
+        "logical_name":  c.LogicalName,
+        "caller_mode":   c.CallerMode.String(),
+        "last_rotated":  formatTime(c.LastRotated),
+        "next_rotation": formatTime(c.NextRotation),
+    }
+}
+
+// scheduleNextRotation sets the time of the next automatic rotation relative to the given time,
+//  picking a random point within the rotation window if there is one
+func (c *astraConfig) scheduleNextRotation(from time.Time) {
+    if c.RotationPeriod <= 0 {
+        c.NextRotation = time.Time{}
+        return
+    }
+    next := from.Add(c.RotationPeriod)
+    if c.RotationWindow > 0 {
+        next = next.Add(time.Duration(rand.Int63n(int64(c.RotationWindow))))
+    }
+    c.NextRotation = next
+}
+
+// redactURL replaces the credentials of a URL, such as those of an authenticating proxy, with a placeholder
+func redactURL(rawURL string) string {
+    u, err := neturl.Parse(rawURL)
+    if err != nil || u.User == nil {
+        return rawURL
+    }
+    u.User = neturl.User("redacted")
+    return u.String()
+}
+
+// formatTime returns the time in RFC3339 format, or an empty string if it has not been set
+func formatTime(t time.Time) string {
+    if t.IsZero() {
+        return ""
     }
+    return t.Format(time.RFC3339)
 }
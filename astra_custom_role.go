@@ -0,0 +1,159 @@
+package datastax_astra
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	dsAstraClient "github.com/datastax/astra-client-go/v2/astra"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// managedRoleNamePrefix prefixes the name of the Astra custom roles the plugin manages
+const managedRoleNamePrefix = "vault-"
+
+// parsePolicy decodes and checks an Astra policy document, e.g.
+//
+//	{"actions": ["db-cql", "db-table-select"], "resources": ["drn:astra:org:<org ID>:db:<db ID>:keyspace:<keyspace>"]}
+func parsePolicy(raw string, description string) (*dsAstraClient.Policy, error) {
+	policy := &dsAstraClient.Policy{}
+	decoder := json.NewDecoder(bytes.NewBufferString(raw))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(policy)
+	if err != nil {
+		return nil, errors.New("unable to parse policy as an astra policy document: " + err.Error())
+	}
+	if len(policy.Actions) == 0 {
+		return nil, errors.New("policy must grant at least one action")
+	}
+	if len(policy.Resources) == 0 {
+		return nil, errors.New("policy must apply to at least one resource")
+	}
+	if policy.Effect == "" {
+		policy.Effect = dsAstraClient.PolicyEffectAllow
+	}
+	if policy.Effect != dsAstraClient.PolicyEffectAllow {
+		return nil, errors.New("unsupported policy effect '" + string(policy.Effect) + "'; only 'allow' is supported")
+	}
+	if policy.Description == "" {
+		policy.Description = description
+	}
+
+	return policy, nil
+}
+
+// upsertManagedRole creates the Astra custom role owned by a Vault role, or updates it if the Vault role
+// already has one. It returns the ID of the Astra role if a new one was created, so the caller can delete
+// it again should saving the Vault role fail.
+func (b *datastaxAstraBackend) upsertManagedRole(ctx context.Context, s logical.Storage, role *astraRoleEntry, policy *dsAstraClient.Policy) (string, error) {
+	client, err := b.getClient(ctx, s, role.OrgId)
+	if err != nil {
+		return "", fmt.Errorf("error getting client: %w", err)
+	}
+
+	name := managedRoleNamePrefix + role.RoleName
+	if role.Policy != nil && len(role.RoleIds) == 1 {
+		err = client.UpdateCustomRole(ctx, role.RoleIds[0], name, *policy)
+		if err == nil {
+			role.Policy = policy
+			role.AstraRoleNames = []string{name}
+			return "", nil
+		}
+		var apiErr *AstraAPIError
+		// The role was deleted in Astra behind our back; create it again below
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			return "", fmt.Errorf("error updating astra role %s: %w", role.RoleIds[0], err)
+		}
+		b.logger.Warn("Astra role " + role.RoleIds[0] + " of role " + role.RoleName + " no longer exists; creating it again")
+	}
+
+	astraRole, err := client.CreateCustomRole(ctx, name, *policy)
+	if err != nil {
+		return "", fmt.Errorf("error creating astra role: %w", err)
+	}
+	if stringValue(astraRole.Id) == "" {
+		return "", errors.New("astra returned a role without an ID")
+	}
+
+	role.Policy = policy
+	role.RoleIds = []string{*astraRole.Id}
+	role.AstraRoleNames = []string{name}
+	return *astraRole.Id, nil
+}
+
+// deleteManagedRole deletes the Astra custom role owned by a Vault role, if it has one
+func (b *datastaxAstraBackend) deleteManagedRole(ctx context.Context, s logical.Storage, role *astraRoleEntry) error {
+	if role.Policy == nil || len(role.RoleIds) == 0 {
+		return nil
+	}
+
+	client, err := b.getClient(ctx, s, role.OrgId)
+	if err != nil {
+		return fmt.Errorf("error getting client: %w", err)
+	}
+	for _, roleId := range role.RoleIds {
+		err = client.DeleteCustomRole(ctx, roleId)
+		if err != nil {
+			return fmt.Errorf("error deleting astra role %s: %w", roleId, err)
+		}
+	}
+
+	return nil
+}
+
+// intersectPolicy narrows a policy requested along with a token down to the actions and resources its role
+// allows, which may contain '*' globs. Anything the role doesn't allow is dropped and reported in the warnings.
+func intersectPolicy(role *astraRoleEntry, requested *dsAstraClient.Policy) (*dsAstraClient.Policy, []string, error) {
+	if len(role.AllowedActions) == 0 || len(role.AllowedResources) == 0 {
+		return nil, nil, errors.New("role " + role.RoleName + " does not allow inline policies; set its allowed_actions and allowed_resources")
+	}
+
+	policy := *requested
+	policy.Actions = nil
+	policy.Resources = nil
+	var warnings []string
+	for _, action := range requested.Actions {
+		if strutil.StrListContainsGlob(role.AllowedActions, string(action)) {
+			policy.Actions = append(policy.Actions, action)
+		} else {
+			warnings = append(warnings, "action "+string(action)+" is not allowed by role "+role.RoleName+" and was dropped from the policy")
+		}
+	}
+	for _, resource := range requested.Resources {
+		if strutil.StrListContainsGlob(role.AllowedResources, resource) {
+			policy.Resources = append(policy.Resources, resource)
+		} else {
+			warnings = append(warnings, "resource "+resource+" is not allowed by role "+role.RoleName+" and was dropped from the policy")
+		}
+	}
+	if len(policy.Actions) == 0 || len(policy.Resources) == 0 {
+		return nil, nil, errors.New("policy grants no action on any resource allowed by role " + role.RoleName)
+	}
+
+	return &policy, warnings, nil
+}
+
+// createTemporaryRole creates the Astra custom role that backs a single token issued with an inline policy
+func createTemporaryRole(ctx context.Context, c AstraAPI, role *astraRoleEntry, policy *dsAstraClient.Policy) (string, error) {
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", err
+	}
+
+	astraRole, err := c.CreateCustomRole(ctx, managedRoleNamePrefix+role.RoleName+"-"+hex.EncodeToString(suffix), *policy)
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary astra role: %w", err)
+	}
+	if stringValue(astraRole.Id) == "" {
+		return "", errors.New("astra returned a role without an ID")
+	}
+
+	return *astraRole.Id, nil
+}
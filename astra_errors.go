@@ -0,0 +1,113 @@
+package datastax_astra
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maxErrorBodySize limits how much of an error response is read when looking for Astra's error message
+const maxErrorBodySize = 64 * 1024
+
+// AstraAPIError is returned when the Astra API responds to a request with an unexpected status code.
+type AstraAPIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *AstraAPIError) Error() string {
+	msg := fmt.Sprintf("astra API returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Code != "" {
+		msg += " (code " + e.Code + ")"
+	}
+	return msg
+}
+
+// newAstraAPIError builds an AstraAPIError from a response, using the error details in its body if there are any.
+// Astra reports errors either as {"errors": [{"description": ..., "internalCode": ...}]} or as {"message": ...}.
+func newAstraAPIError(res *http.Response) *AstraAPIError {
+	apiErr := &AstraAPIError{StatusCode: res.StatusCode}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	if err != nil || len(body) == 0 {
+		return apiErr
+	}
+
+	var errorBody struct {
+		Errors []struct {
+			Description  string `json:"description"`
+			Message      string `json:"message"`
+			InternalCode string `json:"internalCode"`
+			ID           int    `json:"ID"`
+		} `json:"errors"`
+		Message string `json:"message"`
+		Code    string `json:"code"`
+	}
+	if err = json.Unmarshal(body, &errorBody); err != nil {
+		apiErr.Message = strings.TrimSpace(string(body))
+		return apiErr
+	}
+
+	apiErr.Message = errorBody.Message
+	apiErr.Code = errorBody.Code
+	var messages []string
+	for _, e := range errorBody.Errors {
+		switch {
+		case e.Description != "":
+			messages = append(messages, e.Description)
+		case e.Message != "":
+			messages = append(messages, e.Message)
+		}
+		if apiErr.Code == "" {
+			if e.InternalCode != "" {
+				apiErr.Code = e.InternalCode
+			} else if e.ID != 0 {
+				apiErr.Code = strconv.Itoa(e.ID)
+			}
+		}
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = strings.Join(messages, "; ")
+	}
+
+	return apiErr
+}
+
+// toVaultError maps errors caused by the Astra API onto errors Vault reports with a matching HTTP status:
+// permission problems become 403, other rejected requests 400, and an unavailable or failing Astra 502.
+func toVaultError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *AstraAPIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+			return logical.CodedError(http.StatusForbidden, err.Error())
+		case apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= http.StatusInternalServerError:
+			return logical.CodedError(http.StatusBadGateway, err.Error())
+		case apiErr.StatusCode >= http.StatusBadRequest:
+			return logical.CodedError(http.StatusBadRequest, err.Error())
+		}
+		return logical.CodedError(http.StatusBadGateway, err.Error())
+	}
+
+	var urlErr *neturl.Error
+	if errors.As(err, &urlErr) {
+		return logical.CodedError(http.StatusBadGateway, err.Error())
+	}
+
+	return err
+}
@@ -0,0 +1,90 @@
+package datastax_astra
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/datastax/vault-plugin-secrets-datastax-astra/astratest"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAstraAPIError(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status      int
+		body        string
+		expected    AstraAPIError
+		vaultStatus int
+	}{
+		"unauthorized": {
+			status:      http.StatusUnauthorized,
+			body:        `{"errors": [{"description": "The token is invalid", "internalCode": "401"}]}`,
+			expected:    AstraAPIError{StatusCode: http.StatusUnauthorized, Code: "401", Message: "The token is invalid"},
+			vaultStatus: http.StatusForbidden,
+		},
+		"bad request": {
+			status:      http.StatusBadRequest,
+			body:        `{"message": "roles must not be empty"}`,
+			expected:    AstraAPIError{StatusCode: http.StatusBadRequest, Message: "roles must not be empty"},
+			vaultStatus: http.StatusBadRequest,
+		},
+		"unavailable": {
+			status:      http.StatusServiceUnavailable,
+			body:        `upstream connect error`,
+			expected:    AstraAPIError{StatusCode: http.StatusServiceUnavailable, Message: "upstream connect error"},
+			vaultStatus: http.StatusBadGateway,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+			client, err := newClient(&astraConfig{AstraToken: "AstraCS:client:secret", URL: server.URL})
+			require.NoError(t, err)
+
+			_, err = createTokenWithRolesInAstra(context.Background(), client, []string{"roleId"})
+			var apiErr *AstraAPIError
+			require.ErrorAs(t, err, &apiErr)
+			require.Equal(t, tc.expected, *apiErr)
+
+			status := http.StatusInternalServerError
+			logical.AdjustErrorStatusCode(&status, toVaultError(err))
+			require.Equal(t, tc.vaultStatus, status)
+		})
+	}
+}
+
+// TestIncompleteTokenNotSaved makes sure a token Astra only partially returned is never stored
+func TestIncompleteTokenNotSaved(t *testing.T) {
+	b, s := getTestBackend(t)
+	deleted := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted = r.URL.Path
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`{"clientId": "partial", "token": ""}`))
+	}))
+	defer server.Close()
+
+	err := testConfigCreate(t, b, s, map[string]interface{}{
+		"astra_token":       "AstraCS:client:secret",
+		"url":               server.URL,
+		"org_id":            org_id,
+		"skip_verification": true,
+	})
+	require.NoError(t, err)
+	role := &astraRoleEntry{RoleName: "role", RoleIds: []string{"roleId"}, OrgId: org_id}
+
+	_, err = b.createToken(context.Background(), &logical.Request{Storage: s}, role, "logical", nil, nil)
+	require.ErrorContains(t, err, "incomplete token")
+	require.Equal(t, astratest.SecretsPath+"/partial", deleted)
+	tokens, err := s.List(context.Background(), "token/")
+	require.NoError(t, err)
+	require.Empty(t, tokens)
+}
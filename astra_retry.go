@@ -0,0 +1,134 @@
+package datastax_astra
+
+import (
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMaxRetries   = 3
+	defaultRetryMinWait = time.Second
+	defaultRetryMaxWait = 30 * time.Second
+)
+
+// retryPolicy describes how often and how long to wait before
+// repeating a failed request to the Astra API.
+type retryPolicy struct {
+	maxRetries int
+	minWait    time.Duration
+	maxWait    time.Duration
+}
+
+func newRetryPolicy(config *astraConfig) retryPolicy {
+	policy := retryPolicy{
+		maxRetries: config.MaxRetries,
+		minWait:    defaultRetryMinWait,
+		maxWait:    defaultRetryMaxWait,
+	}
+	if config.RetryMinWait > 0 {
+		policy.minWait = config.RetryMinWait
+	}
+	if config.RetryMaxWait > 0 {
+		policy.maxWait = config.RetryMaxWait
+	}
+	if policy.maxWait < policy.minWait {
+		policy.maxWait = policy.minWait
+	}
+	return policy
+}
+
+// shouldRetry decides whether a request should be sent again and how long to wait before doing so.
+// Requests that may have had an effect in Astra are only retried when Astra explicitly rejected them
+// with a 429, so a token is never minted twice. Safe and idempotent requests are also retried on
+// connection errors and 5xx responses.
+func (p retryPolicy) shouldRetry(method string, attempt int, res *http.Response, err error) (bool, time.Duration) {
+	if attempt >= p.maxRetries {
+		return false, 0
+	}
+
+	idempotent := method == http.MethodGet || method == http.MethodHead || method == http.MethodDelete ||
+		method == http.MethodPut || method == http.MethodOptions
+	switch {
+	case err != nil:
+		if !idempotent || isCertificateError(err) {
+			return false, 0
+		}
+	case res.StatusCode == http.StatusTooManyRequests:
+	case res.StatusCode >= http.StatusInternalServerError && res.StatusCode != http.StatusNotImplemented:
+		if !idempotent {
+			return false, 0
+		}
+	default:
+		return false, 0
+	}
+
+	if res != nil {
+		if wait, ok := retryAfter(res); ok {
+			if wait > p.maxWait {
+				wait = p.maxWait
+			}
+			return true, wait
+		}
+	}
+	return true, p.backoff(attempt)
+}
+
+// isCertificateError reports whether the request failed because the server's certificate
+// could not be verified, which no amount of retrying will fix
+func isCertificateError(err error) bool {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalidErr x509.CertificateInvalidError
+	return errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &certInvalidErr)
+}
+
+// backoff returns an exponentially growing wait with jitter, bounded by minWait and maxWait
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	wait := p.maxWait
+	if attempt < 32 {
+		if exp := p.minWait << attempt; exp > 0 && exp < p.maxWait {
+			wait = exp
+		}
+	}
+	// Pick a random wait in [wait/2, wait] so clients that failed together do not retry together
+	half := wait / 2
+	wait = half + time.Duration(rand.Int63n(int64(half)+1))
+	if wait < p.minWait {
+		wait = p.minWait
+	}
+	return wait
+}
+
+// retryAfter parses the Retry-After header, which holds either a number of seconds or an HTTP date
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// discardResponse drains and closes the body of a response that will not be used
+// so its connection can be reused for the next attempt
+func discardResponse(res *http.Response) {
+	if res == nil {
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	res.Body.Close()
+}
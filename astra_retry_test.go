@@ -0,0 +1,156 @@
+package datastax_astra
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newRetryTestClient returns a client for the given server that retries quickly
+func newRetryTestClient(t *testing.T, url string) *astraClient {
+	client, err := newClient(&astraConfig{
+		AstraToken:   "AstraCS:client:secret",
+		URL:          url,
+		MaxRetries:   3,
+		RetryMinWait: time.Millisecond,
+		RetryMaxWait: 5 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	return client
+}
+
+func TestRetryIdempotentRequests(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	err := newRetryTestClient(t, server.URL).DeleteToken(context.Background(), "clientId")
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err := newRetryTestClient(t, server.URL).DeleteToken(context.Background(), "clientId")
+	require.ErrorContains(t, err, "503")
+	require.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestRetryTokenCreation(t *testing.T) {
+	var calls int32
+	var status int32 = http.StatusBadGateway
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&status) == http.StatusTooManyRequests {
+			atomic.StoreInt32(&status, http.StatusOK)
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+		w.Write([]byte(`{"clientId": "clientId", "token": "AstraCS:clientId:secret"}`))
+	}))
+	defer server.Close()
+	client := newRetryTestClient(t, server.URL)
+
+	// Astra may already have minted a token, so a 5xx must not be retried
+	_, err := client.CreateToken(context.Background(), []string{"roleId"})
+	var apiErr *AstraAPIError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusBadGateway, apiErr.StatusCode)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// A rate limited request was not processed and can safely be sent again
+	atomic.StoreInt32(&calls, 0)
+	atomic.StoreInt32(&status, http.StatusTooManyRequests)
+	_, err = client.CreateToken(context.Background(), []string{"roleId"})
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestRetryAfter(t *testing.T) {
+	policy := retryPolicy{maxRetries: 1, minWait: time.Second, maxWait: 10 * time.Second}
+	res := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+
+	res.Header.Set("Retry-After", "5")
+	retry, wait := policy.shouldRetry(http.MethodPost, 0, res, nil)
+	require.True(t, retry)
+	require.Equal(t, 5*time.Second, wait)
+
+	res.Header.Set("Retry-After", "120")
+	_, wait = policy.shouldRetry(http.MethodPost, 0, res, nil)
+	require.Equal(t, 10*time.Second, wait)
+
+	retry, _ = policy.shouldRetry(http.MethodPost, 1, res, nil)
+	require.False(t, retry)
+}
+
+// TestRequestCancellation makes sure a cancelled request neither waits for Astra nor keeps retrying
+func TestRequestCancellation(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	defer close(release)
+	client := newRetryTestClient(t, server.URL)
+	client.retryPolicy.minWait = time.Hour
+	client.retryPolicy.maxWait = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.DeleteToken(ctx, "clientId")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+// TestResponseAfterCancellation makes sure a response received as the request is cancelled is returned
+// rather than dropped, so a token Astra created isn't lost
+func TestResponseAfterCancellation(t *testing.T) {
+	client := newRetryTestClient(t, "https://api.astra.datastax.com")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client.httpClient.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		cancel()
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{"clientId": "clientId", "token": "AstraCS:clientId:secret"}`)),
+			Request:    req,
+		}, nil
+	})
+
+	token, err := client.CreateToken(ctx, []string{"roleId"})
+	require.NoError(t, err)
+	require.Equal(t, "clientId", token.ClientId)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
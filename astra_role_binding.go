@@ -0,0 +1,133 @@
+package datastax_astra
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/helper/cidrutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	tokenReadAccessOwner = "owner"
+	tokenReadAccessGroup = "group"
+	tokenReadAccessAny   = "any"
+)
+
+// tokenReadAccessModes lists the valid read_access values of a role
+var tokenReadAccessModes = []string{tokenReadAccessOwner, tokenReadAccessGroup, tokenReadAccessAny}
+
+// hasEntityBindings reports whether a role is restricted to some Vault entities
+func (r *astraRoleEntry) hasEntityBindings() bool {
+	return len(r.BoundEntityIDs) > 0 || len(r.BoundGroupIDs) > 0 || len(r.BoundEntityMetadata) > 0
+}
+
+// checkRoleBindings makes sure the entity and client that made a request may get the tokens of a role. Every
+// kind of binding set on the role must be satisfied; within a kind, matching any of its values is enough.
+func (b *datastaxAstraBackend) checkRoleBindings(req *logical.Request, role *astraRoleEntry) error {
+	denied := func(reason string) error {
+		b.logger.Warn("Denied request for a token of role " + role.RoleName + " in org ID " + role.OrgId + ": " + reason)
+		return logical.CodedError(http.StatusForbidden, "permission denied: "+reason)
+	}
+
+	if len(role.BoundCIDRs) > 0 {
+		if req.Connection == nil || req.Connection.RemoteAddr == "" {
+			return denied("role " + role.RoleName + " is bound to CIDRs but the request has no remote address")
+		}
+		ok, err := cidrutil.IPBelongsToCIDRBlocksSlice(req.Connection.RemoteAddr, role.BoundCIDRs)
+		if err != nil || !ok {
+			return denied("remote address " + req.Connection.RemoteAddr + " is not in the bound_cidrs of role " + role.RoleName)
+		}
+	}
+
+	if !role.hasEntityBindings() {
+		return nil
+	}
+	if req.EntityID == "" {
+		return denied("role " + role.RoleName + " is bound to Vault entities but the request has no entity")
+	}
+	if len(role.BoundEntityIDs) > 0 && !strutil.StrListContains(role.BoundEntityIDs, req.EntityID) {
+		return denied("entity " + req.EntityID + " is not in the bound_entity_ids of role " + role.RoleName)
+	}
+	if len(role.BoundGroupIDs) > 0 {
+		groups, err := b.System().GroupsForEntity(req.EntityID)
+		if err != nil {
+			return errors.New("error retrieving groups of entity " + req.EntityID + ": " + err.Error())
+		}
+		member := false
+		for _, group := range groups {
+			if group != nil && strutil.StrListContains(role.BoundGroupIDs, group.ID) {
+				member = true
+				break
+			}
+		}
+		if !member {
+			return denied("entity " + req.EntityID + " is not a member of any of the bound_group_ids of role " + role.RoleName)
+		}
+	}
+	if len(role.BoundEntityMetadata) > 0 {
+		entity, err := b.System().EntityInfo(req.EntityID)
+		if err != nil {
+			return errors.New("error retrieving entity " + req.EntityID + ": " + err.Error())
+		}
+		for key, value := range role.BoundEntityMetadata {
+			if entity == nil || entity.Metadata[key] != value {
+				return denied("entity " + req.EntityID + " does not have the bound_entity_metadata of role " + role.RoleName)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkTokenReadAccess makes sure the entity that made a request may read back an existing token of a role.
+// Depending on the role's read_access, only the entity that created the token, entities that share an
+// identity group with it, or anyone may. Tokens created by a request without an entity have no owner.
+func (b *datastaxAstraBackend) checkTokenReadAccess(req *logical.Request, role *astraRoleEntry, token *astraToken) error {
+	denied := func() error {
+		b.logger.Warn("Denied read of token " + token.ClientID + " of role " + role.RoleName + " in org ID " + role.OrgId +
+			" by entity '" + req.EntityID + "'; role read_access is " + role.ReadAccess)
+		return logical.CodedError(http.StatusForbidden, "permission denied: the token of role "+role.RoleName+" can only be read back by "+readAccessDescription(role.ReadAccess))
+	}
+
+	switch role.ReadAccess {
+	case tokenReadAccessAny:
+		return nil
+	case tokenReadAccessOwner, tokenReadAccessGroup:
+		if req.EntityID == "" || token.OwnerEntityID == "" {
+			return denied()
+		}
+		if req.EntityID == token.OwnerEntityID {
+			return nil
+		}
+		if role.ReadAccess == tokenReadAccessOwner {
+			return denied()
+		}
+		ownerGroups, err := b.System().GroupsForEntity(token.OwnerEntityID)
+		if err != nil {
+			return errors.New("error retrieving groups of entity " + token.OwnerEntityID + ": " + err.Error())
+		}
+		readerGroups, err := b.System().GroupsForEntity(req.EntityID)
+		if err != nil {
+			return errors.New("error retrieving groups of entity " + req.EntityID + ": " + err.Error())
+		}
+		for _, ownerGroup := range ownerGroups {
+			for _, readerGroup := range readerGroups {
+				if ownerGroup != nil && readerGroup != nil && ownerGroup.ID == readerGroup.ID {
+					return nil
+				}
+			}
+		}
+		return denied()
+	default:
+		return errors.New("role " + role.RoleName + " has an unknown read_access '" + role.ReadAccess + "'")
+	}
+}
+
+func readAccessDescription(readAccess string) string {
+	if readAccess == tokenReadAccessGroup {
+		return "the entity that created it or entities sharing an identity group with it"
+	}
+	return "the entity that created it"
+}
@@ -1,21 +1,88 @@
 package datastax_astra
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	dsAstraClient "github.com/datastax/astra-client-go/v2/astra"
+)
 
 type astraRoleEntry struct {
-	RoleName string        `json:"role_name"`
-	RoleId   string        `json:"role_id"`
-	OrgId    string        `json:"org_id"`
-	TTL      time.Duration `json:"ttl"`
-	MaxTTL   time.Duration `json:"max_ttl"`
+	RoleName       string        `json:"role_name"`
+	RoleIds        []string      `json:"role_ids"`
+	AstraRoleNames []string      `json:"astra_role_names"`
+	OrgId          string        `json:"org_id"`
+	TTL            time.Duration `json:"ttl"`
+	MaxTTL         time.Duration `json:"max_ttl"`
+	// Policy is set on roles whose Astra custom role is created and owned by the plugin
+	Policy *dsAstraClient.Policy `json:"policy,omitempty"`
+	// AllowedActions and AllowedResources bound the inline policies tokens of this role can be requested with
+	AllowedActions   []string `json:"allowed_actions,omitempty"`
+	AllowedResources []string `json:"allowed_resources,omitempty"`
+	// The Bound fields restrict which Vault entities, and from where, can get tokens of this role
+	BoundEntityIDs      []string          `json:"bound_entity_ids,omitempty"`
+	BoundGroupIDs       []string          `json:"bound_group_ids,omitempty"`
+	BoundEntityMetadata map[string]string `json:"bound_entity_metadata,omitempty"`
+	BoundCIDRs          []string          `json:"bound_cidrs,omitempty"`
+	// ReadAccess decides who may read an existing token of this role back; see tokenReadAccessModes
+	ReadAccess string `json:"read_access"`
+	// AstraRolesPinned is set on roles whose Astra roles were given by name or as a list, which roles/sync leaves alone
+	AstraRolesPinned bool `json:"astra_roles_pinned,omitempty"`
+}
+
+// UnmarshalJSON decodes a role entry, including entries stored before
+// a role could map to more than one Astra role or had a read_access.
+func (r *astraRoleEntry) UnmarshalJSON(data []byte) error {
+	type roleEntry astraRoleEntry
+	var entry struct {
+		roleEntry
+		RoleId        string `json:"role_id"`
+		AstraRoleName string `json:"astra_role_name"`
+	}
+	err := json.Unmarshal(data, &entry)
+	if err != nil {
+		return err
+	}
+
+	*r = astraRoleEntry(entry.roleEntry)
+	// Anyone could read tokens back before read_access existed, so keep it that way for those roles
+	if r.ReadAccess == "" {
+		r.ReadAccess = tokenReadAccessAny
+	}
+	if len(r.RoleIds) == 0 && entry.RoleId != "" {
+		r.RoleIds = []string{entry.RoleId}
+		if entry.AstraRoleName != "" {
+			r.AstraRoleNames = []string{entry.AstraRoleName}
+		}
+	}
+	return nil
 }
 
 func (r *astraRoleEntry) ToResponseData() map[string]interface{} {
+	// role_id and astra_role_name are kept for clients that read a single Astra role
+	roleId, astraRoleName := "", ""
+	if len(r.RoleIds) > 0 {
+		roleId = r.RoleIds[0]
+	}
+	if len(r.AstraRoleNames) > 0 {
+		astraRoleName = r.AstraRoleNames[0]
+	}
 	return map[string]interface{}{
-		"role_name": r.RoleName,
-		"role_id":   r.RoleId,
-		"org_id":    r.OrgId,
-		"ttl":       r.TTL.String(),
-		"max_ttl":   r.MaxTTL.String(),
+		"role_name":             r.RoleName,
+		"role_id":               roleId,
+		"astra_role_name":       astraRoleName,
+		"role_ids":              r.RoleIds,
+		"astra_role_names":      r.AstraRoleNames,
+		"org_id":                r.OrgId,
+		"ttl":                   r.TTL.String(),
+		"max_ttl":               r.MaxTTL.String(),
+		"policy":                r.Policy,
+		"allowed_actions":       r.AllowedActions,
+		"allowed_resources":     r.AllowedResources,
+		"bound_entity_ids":      r.BoundEntityIDs,
+		"bound_group_ids":       r.BoundGroupIDs,
+		"bound_entity_metadata": r.BoundEntityMetadata,
+		"bound_cidrs":           r.BoundCIDRs,
+		"read_access":           r.ReadAccess,
 	}
 }
@@ -2,9 +2,9 @@ package datastax_astra
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -12,6 +12,8 @@ import (
 
 const (
 	astraTokenType = "astra_token"
+	// revokedLeaseTTL is what renewing the lease of a token revoked without it cuts the lease down to
+	revokedLeaseTTL = time.Second
 )
 
 // astraToken defines a secret for the astra token
@@ -25,6 +27,13 @@ type astraToken struct {
 	GeneratedOn string            `json:"generatedOn"`
 	LogicalName string            `json:"logicalName"`
 	Metadata    map[string]string `json:"metadata"`
+	// TemporaryRoleId is the Astra custom role created for a token requested with an inline policy
+	TemporaryRoleId string `json:"temporaryRoleId,omitempty"`
+	// OwnerEntityID and OwnerDisplayName identify who created the token, to decide who may read it back
+	OwnerEntityID    string `json:"ownerEntityId,omitempty"`
+	OwnerDisplayName string `json:"ownerDisplayName,omitempty"`
+	// MaxExpiry is the latest time the token's lease can be renewed until, if its role had a max_ttl
+	MaxExpiry string `json:"maxExpiry,omitempty"`
 }
 
 // astraToken defines a secret to store for a given role
@@ -42,32 +51,74 @@ func (b *datastaxAstraBackend) astraToken() *framework.Secret {
 }
 
 func (token *astraToken) ToResponseData() map[string]interface{} {
+	data := token.ToListData()
+	data["token"] = token.Token
+	return data
+}
+
+// ToListData returns the metadata of the token shown when listing tokens, which leaves out the token itself
+func (token *astraToken) ToListData() map[string]interface{} {
 	return map[string]interface{}{
-		"token":       token.Token,
-		"clientId":    token.ClientID,
-		"orgId":       token.OrgID,
-		"roleName":    token.RoleName,
-		"logicalName": token.LogicalName,
-		"generatedOn": token.GeneratedOn,
-		"metadata":    token.Metadata,
+		"clientId":         token.ClientID,
+		"orgId":            token.OrgID,
+		"roleName":         token.RoleName,
+		"roles":            token.Roles,
+		"logicalName":      token.LogicalName,
+		"generatedOn":      token.GeneratedOn,
+		"metadata":         token.Metadata,
+		"ownerEntityId":    token.OwnerEntityID,
+		"ownerDisplayName": token.OwnerDisplayName,
+		"maxExpiry":        token.MaxExpiry,
 	}
 }
 
-func createTokenInAstra(c *astraClient, roleEntry *astraRoleEntry, logicalName string, metadata map[string]string) (*astraToken, error) {
-	response, err := c.createToken(`{"roles": [` + `"` + roleEntry.RoleId + `"]}`)
+// astraClientRoles defines a client ID known to Astra and the roles it was issued with
+type astraClientRoles struct {
+	ClientID    string   `json:"clientId"`
+	Roles       []string `json:"roles"`
+	GeneratedOn string   `json:"generatedOn"`
+}
+
+func createTokenWithRolesInAstra(ctx context.Context, c AstraAPI, roles []string) (*astraToken, error) {
+	response, err := c.CreateToken(ctx, roles)
 	if err != nil {
 		return nil, err
 	}
 
-	var newToken *astraToken
-	err = json.Unmarshal(response, &newToken)
+	token := ""
+	if response.Token != nil {
+		token = *response.Token
+	}
+	if response.ClientId == "" || token == "" {
+		// Don't leave a token we can't hand out behind in Astra
+		if response.ClientId != "" {
+			_ = c.DeleteToken(context.Background(), response.ClientId)
+		}
+		return nil, errors.New("astra returned an incomplete token")
+	}
+
+	return &astraToken{
+		ClientID:    response.ClientId,
+		Secret:      response.Secret,
+		OrgID:       response.OrgId,
+		Roles:       response.Roles,
+		Token:       token,
+		GeneratedOn: response.GeneratedOn,
+	}, nil
+}
+
+func createTokenInAstra(ctx context.Context, c AstraAPI, roleEntry *astraRoleEntry, roles []string, logicalName string, metadata map[string]string) (*astraToken, error) {
+	newToken, err := createTokenWithRolesInAstra(ctx, c, roles)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode JSON response '%s', caused by %w", string(response), err)
+		return nil, err
 	}
 	// Override the orgId with the one specified by the user so there is no confusion when it is displayed back to them
 	// The orgId is only used internally by the plugin and never used when calling out to the Astra API. Astra uses only
 	// the clientId to identify the token.
 	newToken.OrgID = roleEntry.OrgId
+	if len(newToken.Roles) == 0 {
+		newToken.Roles = roles
+	}
 	newToken.RoleName = roleEntry.RoleName
 	newToken.LogicalName = logicalName
 	newToken.Metadata = metadata
@@ -75,8 +126,32 @@ func createTokenInAstra(c *astraClient, roleEntry *astraRoleEntry, logicalName s
 	return newToken, nil
 }
 
-func deleteTokenFromAstra(c *astraClient, clientId string) error {
-	err := c.deleteToken(clientId)
+func listTokensInAstra(ctx context.Context, c AstraAPI) ([]astraClientRoles, error) {
+	response, err := c.ListTokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make([]astraClientRoles, 0, len(response))
+	for _, client := range response {
+		if client.ClientId == nil {
+			continue
+		}
+		clientRoles := astraClientRoles{ClientID: *client.ClientId}
+		if client.Roles != nil {
+			clientRoles.Roles = *client.Roles
+		}
+		if client.GeneratedOn != nil {
+			clientRoles.GeneratedOn = *client.GeneratedOn
+		}
+		clients = append(clients, clientRoles)
+	}
+
+	return clients, nil
+}
+
+func deleteTokenFromAstra(ctx context.Context, c AstraAPI, clientId string) error {
+	err := c.DeleteToken(ctx, clientId)
 	if err != nil {
 		return err
 	}
@@ -85,10 +160,17 @@ func deleteTokenFromAstra(c *astraClient, clientId string) error {
 }
 
 func deleteTokenFromStorage(ctx context.Context, s logical.Storage, tokenId string) error {
-	err := s.Delete(ctx, "token/"+tokenId)
+	token, err := readToken(ctx, s, tokenId)
 	if err != nil {
 		return err
 	}
+	err = s.Delete(ctx, "token/"+tokenId)
+	if err != nil {
+		return err
+	}
+	if token != nil {
+		return deleteTokenIndex(ctx, s, token.ClientID, tokenId)
+	}
 
 	return nil
 }
@@ -113,6 +195,24 @@ func (b *datastaxAstraBackend) tokenRenew(ctx context.Context, req *logical.Requ
 		return nil, errors.New("unable to find role " + roleName)
 	}
 
+	// A token revoked through the org/token endpoints is gone even though its lease isn't. The plugin can't
+	//	revoke the lease itself, so its renewal is cut short instead, and Vault revokes it once it runs out.
+	tokenId, _ := req.Secret.InternalData["tokenId"].(string)
+	clientId, _ := req.Secret.InternalData["clientId"].(string)
+	if tokenId != "" {
+		token, err := readToken(ctx, req.Storage, tokenId)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving token %s: %w", clientId, err)
+		}
+		if token == nil || token.ClientID != clientId {
+			resp := &logical.Response{Secret: req.Secret}
+			resp.Secret.TTL = revokedLeaseTTL
+			resp.AddWarning("token " + clientId + " has been revoked; its lease ends in " + revokedLeaseTTL.String())
+			b.logger.Info("Ending lease of revoked token '" + clientId + "'")
+			return resp, nil
+		}
+	}
+
 	resp := &logical.Response{Secret: req.Secret}
 
 	if roleEntry.TTL > 0 {
@@ -150,10 +250,14 @@ func (b *datastaxAstraBackend) tokenRevoke(ctx context.Context, req *logical.Req
 		}
 	}
 
-	err = deleteTokenFromAstra(client, clientId)
+	err = deleteTokenFromAstra(ctx, client, clientId)
 	if err != nil {
 		return nil, fmt.Errorf("error revoking user token: %s", err.Error())
 	}
+	err = forgetMinted(ctx, req.Storage, mintedTokenStoragePath, clientId)
+	if err != nil {
+		return nil, err
+	}
 
 	tokenId := ""
 	tokenIdRaw, ok := req.Secret.InternalData["tokenId"]
@@ -163,8 +267,70 @@ func (b *datastaxAstraBackend) tokenRevoke(ctx context.Context, req *logical.Req
 			return nil, fmt.Errorf("invalid value for client Id in secret internal data")
 		}
 	}
-	err = deleteTokenFromStorage(ctx, req.Storage, tokenId)
+	// If the token was revoked through the org/token endpoints or rotated, its storage entry may
+	//	since have been reused by a new token with the same logical name
+	token, err := readToken(ctx, req.Storage, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens requested with an inline policy take their temporary Astra role with them, unless
+	//	the token was rotated and its new version still uses the role
+	temporaryRoleId, ok := req.Secret.InternalData["temporaryRoleId"].(string)
+	if ok && temporaryRoleId != "" && (token == nil || token.TemporaryRoleId != temporaryRoleId || token.ClientID == clientId) {
+		err = client.DeleteCustomRole(ctx, temporaryRoleId)
+		if err != nil {
+			return nil, fmt.Errorf("error deleting temporary astra role %s: %w", temporaryRoleId, err)
+		}
+		err = forgetMinted(ctx, req.Storage, mintedRoleStoragePath, temporaryRoleId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if token != nil && token.ClientID == clientId {
+		err = deleteTokenFromStorage(ctx, req.Storage, tokenId)
+	}
 
 	b.logger.Info(fmt.Sprintf("Revoked lease for token '%s'", clientId))
 	return nil, err
 }
+
+// tokenStorageId returns the ID a token is stored under: the hash of its org ID, role name and logical
+//  name in standard mode, or its client ID in sidecar mode
+func tokenStorageId(token *astraToken) string {
+	if token.LogicalName == "" {
+		return token.ClientID
+	}
+	return calculateTokenId(&astraRoleEntry{OrgId: token.OrgID, RoleName: token.RoleName}, token.LogicalName)
+}
+
+// revokeToken deletes a token, along with its temporary Astra role if it has one, from Astra and then from
+//  storage. The plugin has no way to revoke its Vault lease, if any: the lease ends the next time it is renewed,
+//  or else when it expires, and revoking it then no longer has any effect.
+func (b *datastaxAstraBackend) revokeToken(ctx context.Context, s logical.Storage, token *astraToken) error {
+	client, err := b.getClient(ctx, s, token.OrgID)
+	if err != nil {
+		return fmt.Errorf("error getting client: %w", err)
+	}
+	err = deleteTokenFromAstra(ctx, client, token.ClientID)
+	if err != nil {
+		return fmt.Errorf("error revoking user token: %w", err)
+	}
+	err = forgetMinted(ctx, s, mintedTokenStoragePath, token.ClientID)
+	if err != nil {
+		return err
+	}
+	if token.TemporaryRoleId != "" {
+		err = client.DeleteCustomRole(ctx, token.TemporaryRoleId)
+		if err != nil {
+			return fmt.Errorf("error deleting temporary astra role %s: %w", token.TemporaryRoleId, err)
+		}
+		err = forgetMinted(ctx, s, mintedRoleStoragePath, token.TemporaryRoleId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return deleteTokenFromStorage(ctx, s, tokenStorageId(token))
+}
@@ -0,0 +1,111 @@
+package datastax_astra
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	tokenIndexStoragePath = "token-by-client-id/"
+	// tokenIndexBackfilledKey is written once the index covers the tokens stored before it existed
+	tokenIndexBackfilledKey = "token-by-client-id-backfilled"
+)
+
+// tokenIndexEntry maps the client ID of a token to the ID it is stored under
+type tokenIndexEntry struct {
+	TokenID string `json:"tokenId"`
+}
+
+func saveTokenIndex(ctx context.Context, s logical.Storage, clientId, tokenId string) error {
+	entry, err := logical.StorageEntryJSON(tokenIndexStoragePath+clientId, &tokenIndexEntry{TokenID: tokenId})
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func readTokenIndex(ctx context.Context, s logical.Storage, clientId string) (*tokenIndexEntry, error) {
+	entry, err := s.Get(ctx, tokenIndexStoragePath+clientId)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	result := &tokenIndexEntry{}
+	err = entry.DecodeJSON(result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// deleteTokenIndex removes the index entry of a client ID, unless it has since been pointed at another token
+func deleteTokenIndex(ctx context.Context, s logical.Storage, clientId, tokenId string) error {
+	index, err := readTokenIndex(ctx, s, clientId)
+	if err != nil {
+		return err
+	}
+	if index == nil || index.TokenID != tokenId {
+		return nil
+	}
+	return s.Delete(ctx, tokenIndexStoragePath+clientId)
+}
+
+func tokenIndexBackfilled(ctx context.Context, s logical.Storage) (bool, error) {
+	entry, err := s.Get(ctx, tokenIndexBackfilledKey)
+	if err != nil {
+		return false, err
+	}
+	return entry != nil, nil
+}
+
+// backfillTokenIndex indexes the tokens stored before the index existed. It only does any work once; until it
+// has, lookups by client ID fall back to scanning every token.
+func (b *datastaxAstraBackend) backfillTokenIndex(ctx context.Context, s logical.Storage) error {
+	// Only the node that can write to storage should build the index
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceSecondary | consts.ReplicationPerformanceStandby) {
+		return nil
+	}
+	backfilled, err := tokenIndexBackfilled(ctx, s)
+	if err != nil || backfilled {
+		return err
+	}
+
+	tokenIds, err := s.List(ctx, "token/")
+	if err != nil {
+		return errors.New("failed to get token list: " + err.Error())
+	}
+	for _, tokenId := range tokenIds {
+		token, err := readToken(ctx, s, tokenId)
+		if err != nil {
+			return errors.New("unable to retrieve token information: " + err.Error())
+		}
+		if token == nil || token.ClientID == "" {
+			continue
+		}
+		err = saveTokenIndex(ctx, s, token.ClientID, tokenId)
+		if err != nil {
+			return err
+		}
+	}
+	err = s.Put(ctx, &logical.StorageEntry{Key: tokenIndexBackfilledKey, Value: []byte("true")})
+	if err != nil {
+		return err
+	}
+
+	b.logger.Info("Indexed the client IDs of the existing tokens")
+	return nil
+}
+
+func (b *datastaxAstraBackend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	err := b.backfillTokenIndex(ctx, req.Storage)
+	if err != nil {
+		// The periodic function tries again, and lookups scan every token until it succeeds
+		b.logger.Error("Failed to index the client IDs of the existing tokens: " + err.Error())
+	}
+	return nil
+}
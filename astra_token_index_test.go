@@ -0,0 +1,54 @@
+package datastax_astra
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenIndex makes sure tokens can be found by client ID through the index, including tokens stored
+// before it existed once they have been backfilled, and that the index follows tokens being replaced and deleted.
+func TestTokenIndex(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+
+	// A token stored before the index existed is found by scanning until the backfill has run
+	legacy := &astraToken{ClientID: "legacyClientId", OrgID: org_id, RoleName: "app", Token: "AstraCS:legacyClientId:secret"}
+	entry, err := logical.StorageEntryJSON("token/legacyTokenId", legacy)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, entry))
+	token, err := readTokenUsingClientId(ctx, s, "legacyClientId")
+	require.NoError(t, err)
+	require.Equal(t, legacy, token)
+
+	require.NoError(t, b.initialize(ctx, &logical.InitializationRequest{Storage: s}))
+	index, err := readTokenIndex(ctx, s, "legacyClientId")
+	require.NoError(t, err)
+	require.Equal(t, "legacyTokenId", index.TokenID)
+	token, err = readTokenUsingClientId(ctx, s, "legacyClientId")
+	require.NoError(t, err)
+	require.Equal(t, legacy, token)
+	token, err = readTokenUsingClientId(ctx, s, "unknownClientId")
+	require.NoError(t, err)
+	require.Nil(t, token)
+
+	// Replacing a token, as rotation does, moves the index over to the new client ID
+	rotated := &astraToken{ClientID: "rotatedClientId", OrgID: org_id, RoleName: "app", Token: "AstraCS:rotatedClientId:secret"}
+	require.NoError(t, saveToken(ctx, s, rotated, "legacyTokenId"))
+	token, err = readTokenUsingClientId(ctx, s, "rotatedClientId")
+	require.NoError(t, err)
+	require.Equal(t, rotated, token)
+	index, err = readTokenIndex(ctx, s, "legacyClientId")
+	require.NoError(t, err)
+	require.Nil(t, index)
+
+	require.NoError(t, deleteTokenFromStorage(ctx, s, "legacyTokenId"))
+	index, err = readTokenIndex(ctx, s, "rotatedClientId")
+	require.NoError(t, err)
+	require.Nil(t, index)
+	token, err = readTokenUsingClientId(ctx, s, "rotatedClientId")
+	require.NoError(t, err)
+	require.Nil(t, token)
+}
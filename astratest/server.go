@@ -0,0 +1,410 @@
+// Package astratest provides a stateful, in-process fake of the parts of the Astra DevOps API
+// used by the DataStax Astra secrets engine, for use in tests.
+package astratest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SecretsPath is the path of the clientIdSecrets endpoints
+	SecretsPath = "/v2/clientIdSecrets"
+	// CurrentOrgPath is the path of the current organization endpoint
+	CurrentOrgPath = "/v2/currentOrg"
+	// RolesPath is the path of the organization roles endpoints
+	RolesPath = "/v2/organizations/roles"
+)
+
+// Token is a token issued by the fake Astra API
+type Token struct {
+	ClientID    string   `json:"clientId"`
+	Secret      string   `json:"secret"`
+	OrgID       string   `json:"orgId"`
+	Roles       []string `json:"roles"`
+	Token       string   `json:"token"`
+	GeneratedOn string   `json:"generatedOn"`
+}
+
+// Role is a role in the organization's role catalogue
+type Role struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	Policy *Policy `json:"policy,omitempty"`
+}
+
+// Policy is the policy document of a custom role
+type Policy struct {
+	Description string   `json:"description"`
+	Resources   []string `json:"resources"`
+	Actions     []string `json:"actions"`
+	Effect      string   `json:"effect"`
+}
+
+// Failure describes a fault injected into the responses of the fake Astra API
+type Failure struct {
+	// Method and PathPrefix select the requests the failure applies to. Empty values match any request.
+	Method     string
+	PathPrefix string
+	// Latency delays the response. The request is still processed unless StatusCode is set.
+	Latency time.Duration
+	// StatusCode makes the request fail with the given status, e.g. 429 or 503, without processing it
+	StatusCode int
+	// RetryAfter is sent as the Retry-After header along with StatusCode
+	RetryAfter string
+	// MalformedJSON processes the request but replaces the response body with invalid JSON
+	MalformedJSON bool
+	// Count is the number of requests the failure applies to. 0 applies it until the failures are cleared.
+	Count int
+}
+
+// Server is a fake Astra DevOps API for a single organization, running on an httptest.Server
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	orgId    string
+	tokens   map[string]Token
+	roles    map[string]Role
+	failures []*Failure
+	requests []string
+}
+
+// NewServer starts a fake Astra API for the given organization. It has no tokens until AddToken is called.
+func NewServer(orgId string) *Server {
+	s := newServer(orgId)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// NewTLSServer starts a fake Astra API for the given organization over HTTPS, using the
+// self-signed certificate of the underlying httptest.Server.
+func NewTLSServer(orgId string) *Server {
+	s := newServer(orgId)
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func newServer(orgId string) *Server {
+	return &Server{
+		orgId:  orgId,
+		tokens: make(map[string]Token),
+		roles:  make(map[string]Role),
+	}
+}
+
+// OrgID returns the ID of the organization served by the fake
+func (s *Server) OrgID() string {
+	return s.orgId
+}
+
+// AddToken issues a token with the given roles directly, e.g. to use as the root token of a config
+func (s *Server) AddToken(roles ...string) Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issueToken(roles)
+}
+
+// Token returns the token with the given client ID, if it exists
+func (s *Server) Token(clientId string) (Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[clientId]
+	return token, ok
+}
+
+// ClientIDs returns the sorted client IDs of all existing tokens
+func (s *Server) ClientIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clientIds := make([]string, 0, len(s.tokens))
+	for clientId := range s.tokens {
+		clientIds = append(clientIds, clientId)
+	}
+	sort.Strings(clientIds)
+	return clientIds
+}
+
+// DeleteToken removes a token as if it had been deleted in the Astra UI
+func (s *Server) DeleteToken(clientId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, clientId)
+}
+
+// AddRole adds a role with the given name to the organization's role catalogue
+func (s *Server) AddRole(name string) Role {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	role := Role{ID: randomUUID(), Name: name}
+	s.roles[role.ID] = role
+	return role
+}
+
+// Role returns the role with the given ID, if it exists
+func (s *Server) Role(roleId string) (Role, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	role, ok := s.roles[roleId]
+	return role, ok
+}
+
+// Roles returns the organization's role catalogue, sorted by name
+func (s *Server) Roles() []Role {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedRoles()
+}
+
+// DeleteRole removes a role from the catalogue as if it had been deleted in the Astra UI
+func (s *Server) DeleteRole(roleId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.roles, roleId)
+}
+
+// InjectFailure adds a failure to apply to matching requests. Failures are matched in the order they were added.
+func (s *Server) InjectFailure(f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &f)
+}
+
+// ClearFailures removes all injected failures
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = nil
+}
+
+// Requests returns every request received so far as "METHOD /path"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	failure := s.takeFailure(r)
+	s.mu.Unlock()
+
+	if failure != nil && failure.Latency > 0 {
+		select {
+		case <-time.After(failure.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if failure != nil && failure.StatusCode != 0 {
+		if failure.RetryAfter != "" {
+			w.Header().Set("Retry-After", failure.RetryAfter)
+		}
+		writeError(w, failure.StatusCode, "injected failure")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authenticated(r) {
+		writeError(w, http.StatusUnauthorized, "The token is not valid")
+		return
+	}
+
+	var status int
+	var body interface{}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == CurrentOrgPath:
+		status, body = http.StatusOK, map[string]string{"id": s.orgId}
+	case r.Method == http.MethodGet && r.URL.Path == SecretsPath:
+		status, body = http.StatusOK, s.listTokens()
+	case r.Method == http.MethodPost && r.URL.Path == SecretsPath:
+		status, body = s.createToken(r)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, SecretsPath+"/"):
+		status, body = s.deleteToken(strings.TrimPrefix(r.URL.Path, SecretsPath+"/"))
+	case r.Method == http.MethodGet && r.URL.Path == RolesPath:
+		status, body = http.StatusOK, s.sortedRoles()
+	case r.Method == http.MethodPost && r.URL.Path == RolesPath:
+		status, body = s.writeRole(r, "")
+	case strings.HasPrefix(r.URL.Path, RolesPath+"/"):
+		roleId := strings.TrimPrefix(r.URL.Path, RolesPath+"/")
+		role, ok := s.roles[roleId]
+		switch {
+		case !ok:
+			status, body = http.StatusNotFound, errorBody("role "+roleId+" not found")
+		case r.Method == http.MethodGet:
+			status, body = http.StatusOK, role
+		case r.Method == http.MethodPut:
+			status, body = s.writeRole(r, roleId)
+		case r.Method == http.MethodDelete:
+			delete(s.roles, roleId)
+			status = http.StatusNoContent
+		default:
+			status, body = http.StatusMethodNotAllowed, errorBody("method not allowed")
+		}
+	default:
+		status, body = http.StatusNotFound, errorBody("not found")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body == nil {
+		return
+	}
+	if failure != nil && failure.MalformedJSON {
+		w.Write([]byte(`{"malformed": `))
+		return
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+// takeFailure returns the first injected failure matching the request, consuming one of its uses
+func (s *Server) takeFailure(r *http.Request) *Failure {
+	for i, f := range s.failures {
+		if f.Method != "" && f.Method != r.Method {
+			continue
+		}
+		if f.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, f.PathPrefix) {
+			continue
+		}
+		if f.Count > 0 {
+			f.Count--
+			if f.Count == 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+func (s *Server) authenticated(r *http.Request) bool {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for _, token := range s.tokens {
+		if token.Token == bearer {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) listTokens() interface{} {
+	type client struct {
+		ClientID    string   `json:"clientId"`
+		Roles       []string `json:"roles"`
+		GeneratedOn string   `json:"generatedOn"`
+	}
+	clients := make([]client, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		clients = append(clients, client{ClientID: token.ClientID, Roles: token.Roles, GeneratedOn: token.GeneratedOn})
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ClientID < clients[j].ClientID })
+	return map[string]interface{}{"clients": clients}
+}
+
+func (s *Server) createToken(r *http.Request) (int, interface{}) {
+	var payload struct {
+		Roles []string `json:"roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return http.StatusBadRequest, errorBody("invalid request body: " + err.Error())
+	}
+	if len(payload.Roles) == 0 {
+		return http.StatusBadRequest, errorBody("roles must not be empty")
+	}
+	return http.StatusOK, s.issueToken(payload.Roles)
+}
+
+// writeRole creates a custom role, or updates the role with the given ID
+func (s *Server) writeRole(r *http.Request, roleId string) (int, interface{}) {
+	var payload struct {
+		Name   string  `json:"name"`
+		Policy *Policy `json:"policy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return http.StatusBadRequest, errorBody("invalid request body: " + err.Error())
+	}
+	if payload.Name == "" || payload.Policy == nil || len(payload.Policy.Actions) == 0 || len(payload.Policy.Resources) == 0 {
+		return http.StatusBadRequest, errorBody("name, policy actions and policy resources must not be empty")
+	}
+
+	status := http.StatusOK
+	if roleId == "" {
+		roleId = randomUUID()
+		status = http.StatusCreated
+	}
+	role := Role{ID: roleId, Name: payload.Name, Policy: payload.Policy}
+	s.roles[roleId] = role
+	return status, role
+}
+
+func (s *Server) deleteToken(clientId string) (int, interface{}) {
+	if _, ok := s.tokens[clientId]; !ok {
+		return http.StatusNotFound, errorBody("client ID " + clientId + " not found")
+	}
+	delete(s.tokens, clientId)
+	return http.StatusNoContent, nil
+}
+
+func (s *Server) sortedRoles() []Role {
+	roles := make([]Role, 0, len(s.roles))
+	for _, role := range s.roles {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		if roles[i].Name != roles[j].Name {
+			return roles[i].Name < roles[j].Name
+		}
+		return roles[i].ID < roles[j].ID
+	})
+	return roles
+}
+
+func (s *Server) issueToken(roles []string) Token {
+	clientId := randomHex(12)
+	token := Token{
+		ClientID:    clientId,
+		Secret:      randomHex(32),
+		OrgID:       s.orgId,
+		Roles:       append([]string(nil), roles...),
+		GeneratedOn: time.Now().UTC().Format(time.RFC3339),
+	}
+	token.Token = "AstraCS:" + clientId + ":" + randomHex(32)
+	s.tokens[clientId] = token
+	return token
+}
+
+func errorBody(message string) interface{} {
+	return map[string]interface{}{
+		"errors": []map[string]interface{}{{"description": message}},
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody(fmt.Sprintf("%s (%d)", message, status)))
+}
+
+func randomUUID() string {
+	id := randomHex(16)
+	return id[0:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:32]
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
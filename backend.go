@@ -6,9 +6,11 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	logHelper "github.com/hashicorp/vault/sdk/helper/logging"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -30,9 +32,15 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 // target API's client.
 type datastaxAstraBackend struct {
 	*framework.Backend
-	lock   sync.RWMutex
-	client *astraClient
-	logger log.Logger
+	lock             sync.RWMutex
+	rotationLock     sync.Mutex
+	clients          map[string]AstraAPI
+	rotationBackoffs map[string]*rotationBackoff
+	// lastTidy records when each org was last tidied on schedule by this node
+	lastTidy map[string]time.Time
+	logger   log.Logger
+	// clientFactory builds the client used to call an org's Astra API from its config
+	clientFactory func(config *astraConfig) (AstraAPI, error)
 }
 
 // backend defines the target API backend
@@ -41,6 +49,10 @@ type datastaxAstraBackend struct {
 func backend() *datastaxAstraBackend {
 	var b = datastaxAstraBackend{}
 	b.logger = NewLogger()
+	b.clients = make(map[string]AstraAPI)
+	b.clientFactory = newAstraAPI
+	b.rotationBackoffs = make(map[string]*rotationBackoff)
+	b.lastTidy = make(map[string]time.Time)
 	b.Backend = &framework.Backend{
 		Help: strings.TrimSpace(backendHelp),
 		PathsSpecial: &logical.Paths{
@@ -56,18 +68,26 @@ func backend() *datastaxAstraBackend {
 		Paths: framework.PathAppend(
 			[]*framework.Path{
 				pathConfig(&b),
+				pathConfigRotateRoot(&b),
+				pathConfigReveal(&b),
 				pathConfigList(&b),
-				pathRole(&b),
 				pathRoleList(&b),
-				pathCredentials(&b),
+				pathRolesSync(&b),
 				pathCredentialsList(&b),
+				pathTidy(&b),
 			},
+			pathRole(&b),
+			pathCredentials(&b),
+			pathCredentialsRotate(&b),
+			pathAstraTokens(&b),
 		),
 		Secrets: []*framework.Secret{
 			b.astraToken(),
 		},
-		BackendType: logical.TypeLogical,
-		Invalidate:  b.invalidate,
+		BackendType:    logical.TypeLogical,
+		Invalidate:     b.invalidate,
+		InitializeFunc: b.initialize,
+		PeriodicFunc:   b.periodicFunc,
 	}
 	return &b
 }
@@ -95,35 +115,40 @@ func NewLogger() log.Logger {
 	})
 }
 
-// reset clears any client configuration for a new
-// backend to be configured
-func (b *datastaxAstraBackend) reset() {
+// resetClient evicts the cached client for a single org
+// so the next invocation picks up its new configuration
+func (b *datastaxAstraBackend) resetClient(orgId string) {
 	b.lock.Lock()
 	defer b.lock.Unlock()
-	b.client = nil
+	delete(b.clients, orgId)
 }
 
 // invalidate clears an existing client configuration in
 // the backend
 func (b *datastaxAstraBackend) invalidate(ctx context.Context, key string) {
-	if key == "config" {
-		b.reset()
+	if strings.HasPrefix(key, configStoragePath) {
+		b.resetClient(strings.TrimPrefix(key, configStoragePath))
 	}
 }
 
-func (b *datastaxAstraBackend) getClient(ctx context.Context, s logical.Storage, orgId string) (*astraClient, error) {
+func (b *datastaxAstraBackend) getClient(ctx context.Context, s logical.Storage, orgId string) (AstraAPI, error) {
 	b.lock.RLock()
 	unlockFunc := b.lock.RUnlock
 	defer func() { unlockFunc() }()
 
-	if b.client != nil {
-		return b.client, nil
+	if client, ok := b.clients[orgId]; ok {
+		return client, nil
 	}
 
 	b.lock.RUnlock()
 	b.lock.Lock()
 	unlockFunc = b.lock.Unlock
 
+	// Another request may have created the client while we were waiting for the write lock
+	if client, ok := b.clients[orgId]; ok {
+		return client, nil
+	}
+
 	config, err := readConfig(ctx, s, orgId)
 	if err != nil {
 		return nil, err
@@ -132,12 +157,35 @@ func (b *datastaxAstraBackend) getClient(ctx context.Context, s logical.Storage,
 		return nil, errors.New("unable to find config for org ID " + orgId)
 	}
 
-	b.client, err = newClient(config)
+	client, err := b.clientFactory(config)
 	if err != nil {
 		return nil, err
 	}
+	b.clients[orgId] = client
+
+	return client, nil
+}
 
-	return b.client, nil
+// periodicFunc runs the backend's scheduled maintenance tasks
+func (b *datastaxAstraBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	// Only the node that can write to storage should rotate tokens
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceSecondary | consts.ReplicationPerformanceStandby) {
+		return nil
+	}
+	rotateErr := b.rotateDueRootTokens(ctx, req)
+	retiredErr := b.deleteRetiredTokens(ctx, req.Storage, time.Now())
+	indexErr := b.backfillTokenIndex(ctx, req.Storage)
+	tidyErr := b.tidyDueOrgs(ctx, req.Storage, time.Now())
+	if rotateErr != nil {
+		return rotateErr
+	}
+	if retiredErr != nil {
+		return retiredErr
+	}
+	if indexErr != nil {
+		return indexErr
+	}
+	return tidyErr
 }
 
 func operationToStringVerb(op logical.Operation) string {
@@ -2,6 +2,7 @@ package datastax_astra
 
 import (
 	"context"
+	"github.com/datastax/vault-plugin-secrets-datastax-astra/astratest"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/require"
@@ -11,14 +12,11 @@ import (
 
 // Fill in the config details to test.
 const (
-	envVarAstraToken       = "AstraCS:Th!s1safAk3T0K3n"
 	envVarAstraOrgId       = "TestOrgId"
 	envVarAstraLogicalName = "TestLogicalName"
-	envVarAstraURL         = "http://localhost:" + mockLocalServerPort
 	envVarRoleName         = "TestRoleName"
 	envVarTTL              = 3600
 	envVarMaxTTL           = 36000
-	envVarRoleId           = "TestRoleId"
 	envVarCallerMode       = "standard"
 )
 
@@ -39,6 +37,35 @@ func getTestBackend(tb testing.TB) (*datastaxAstraBackend, logical.Storage) {
 	return b.(*datastaxAstraBackend), config.StorageView
 }
 
+// newConfiguredTestBackend constructs a test backend with a config for org_id
+// against a test Astra server, which is closed when the test ends.
+func newConfiguredTestBackend(t *testing.T) (*datastaxAstraBackend, logical.Storage, *astratest.Server) {
+	t.Helper()
+
+	b, s := getTestBackend(t)
+	server := configureTestBackend(t, b, s, nil)
+	return b, s, server
+}
+
+// configureTestBackend writes a config for org_id, with any other config arguments given,
+// against a new test Astra server, which is closed when the test ends.
+func configureTestBackend(t *testing.T, b logical.Backend, s logical.Storage, data map[string]interface{}) *astratest.Server {
+	t.Helper()
+
+	server := astratest.NewServer(org_id)
+	t.Cleanup(server.Close)
+	config := map[string]interface{}{
+		"astra_token": server.AddToken("adminRoleId").Token,
+		"url":         server.URL,
+		"org_id":      org_id,
+	}
+	for key, value := range data {
+		config[key] = value
+	}
+	require.NoError(t, testConfigCreate(t, b, s, config))
+	return server
+}
+
 // testEnv creates an object to store and track testing environment
 // resources
 type testEnv struct {
@@ -51,6 +78,7 @@ type testEnv struct {
 	MaxTTL      time.Duration
 	RoleId      string
 	CallerMode  string
+	EntityId    string
 	response    *logical.Response
 
 	Backend logical.Backend
@@ -105,6 +133,7 @@ func (e *testEnv) WriteUserToken(t *testing.T) {
 		Operation: logical.UpdateOperation,
 		Path:      "org/token",
 		Storage:   e.Storage,
+		EntityID:  e.EntityId,
 		Data: map[string]interface{}{
 			"org_id":       e.OrgId,
 			"logical_name": e.LogicalName,
@@ -133,6 +162,7 @@ func (e *testEnv) ReadUserToken(t *testing.T) {
 		Operation: logical.ReadOperation,
 		Path:      "org/token",
 		Storage:   e.Storage,
+		EntityID:  e.EntityId,
 		Data: map[string]interface{}{
 			"org_id":       e.OrgId,
 			"logical_name": e.LogicalName,
@@ -146,13 +176,17 @@ func (e *testEnv) ReadUserToken(t *testing.T) {
 	require.NotNil(t, resp.Data["logicalName"])
 	if envVarCallerMode == "standard" {
 		expectedResp := map[string]interface{}{
-			"clientId":    e.response.Data["clientId"],
-			"generatedOn": e.response.Data["generatedOn"],
-			"logicalName": "testlogicalname",
-			"metadata":    map[string]string{},
-			"orgId":       e.OrgId,
-			"roleName":    "testrolename",
-			"token":       e.response.Data["token"],
+			"clientId":         e.response.Data["clientId"],
+			"generatedOn":      e.response.Data["generatedOn"],
+			"logicalName":      "testlogicalname",
+			"maxExpiry":        e.response.Data["maxExpiry"],
+			"metadata":         map[string]string{},
+			"orgId":            e.OrgId,
+			"ownerEntityId":    e.EntityId,
+			"ownerDisplayName": "",
+			"roleName":         "testrolename",
+			"roles":            []string{e.RoleId},
+			"token":            e.response.Data["token"],
 		}
 		require.Equal(t, expectedResp, resp.Data)
 	}
@@ -165,6 +199,7 @@ func (e *testEnv) ReadUserTokenUsingClientId(t *testing.T) {
 		Operation: logical.ReadOperation,
 		Path:      "org/token",
 		Storage:   e.Storage,
+		EntityID:  e.EntityId,
 		Data: map[string]interface{}{
 			"client_id": e.response.Data["clientId"],
 			"org_id":    e.OrgId,
@@ -177,13 +212,17 @@ func (e *testEnv) ReadUserTokenUsingClientId(t *testing.T) {
 	require.NotNil(t, resp.Data["logicalName"])
 	if envVarCallerMode == "standard" {
 		expectedResp := map[string]interface{}{
-			"clientId":    e.response.Data["clientId"],
-			"generatedOn": e.response.Data["generatedOn"],
-			"logicalName": "testlogicalname",
-			"metadata":    map[string]string{},
-			"orgId":       e.OrgId,
-			"roleName":    "testrolename",
-			"token":       e.response.Data["token"],
+			"clientId":         e.response.Data["clientId"],
+			"generatedOn":      e.response.Data["generatedOn"],
+			"logicalName":      "testlogicalname",
+			"maxExpiry":        e.response.Data["maxExpiry"],
+			"metadata":         map[string]string{},
+			"orgId":            e.OrgId,
+			"ownerEntityId":    e.EntityId,
+			"ownerDisplayName": "",
+			"roleName":         "testrolename",
+			"roles":            []string{e.RoleId},
+			"token":            e.response.Data["token"],
 		}
 		require.Equal(t, expectedResp, resp.Data)
 	}
@@ -224,3 +263,52 @@ func (e *testEnv) RevokeToken(t *testing.T) {
 	require.Nil(t, resp)
 	require.Nil(t, err)
 }
+
+// TestClientPerOrg makes sure each org gets its own client and that
+// writing one org's config only evicts that org's cached client.
+func TestClientPerOrg(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+
+	for _, orgId := range []string{"orgA", "orgB"} {
+		err := testConfigCreate(t, b, s, map[string]interface{}{
+			"astra_token":       "AstraCS:" + orgId,
+			"url":               "http://" + orgId,
+			"org_id":            orgId,
+			"skip_verification": true,
+		})
+		require.NoError(t, err)
+	}
+
+	clientA, err := b.getClient(ctx, s, "orgA")
+	require.NoError(t, err)
+	clientB, err := b.getClient(ctx, s, "orgB")
+	require.NoError(t, err)
+	require.Equal(t, "http://orgA", clientA.(*astraClient).url)
+	require.Equal(t, "http://orgB", clientB.(*astraClient).url)
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"org_id":            "orgA",
+			"url":               "http://orgA-updated",
+			"skip_verification": true,
+		},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	clientA, err = b.getClient(ctx, s, "orgA")
+	require.NoError(t, err)
+	require.Equal(t, "http://orgA-updated", clientA.(*astraClient).url)
+	cachedB, err := b.getClient(ctx, s, "orgB")
+	require.NoError(t, err)
+	require.Same(t, clientB, cachedB)
+
+	b.invalidate(ctx, configStoragePath+"orgB")
+	cachedB, err = b.getClient(ctx, s, "orgB")
+	require.NoError(t, err)
+	require.NotSame(t, clientB, cachedB)
+}
@@ -0,0 +1,319 @@
+package datastax_astra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathAstraTokens extends the Vault API with `/org/astra-tokens`, which lists every token of an org in Astra,
+// including those created outside of Vault, and `/org/astra-tokens/adopt`, which brings one under Vault management.
+func pathAstraTokens(b *datastaxAstraBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "org/astra-tokens",
+			Fields: map[string]*framework.FieldSchema{
+				"org_id": {
+					Type:        framework.TypeString,
+					Description: "UUID of the organization in Astra whose tokens are listed",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathAstraTokensRead,
+					Summary:  "List every token of an org in Astra and whether Vault manages it.",
+				},
+			},
+			HelpSynopsis:    pathAstraTokensHelpSyn,
+			HelpDescription: pathAstraTokensHelpDesc,
+		},
+		{
+			Pattern: "org/astra-tokens/adopt",
+			Fields: map[string]*framework.FieldSchema{
+				"org_id": {
+					Type:        framework.TypeString,
+					Description: "UUID of the organization in Astra the token belongs to",
+					Required:    true,
+				},
+				"client_id": {
+					Type:        framework.TypeString,
+					Description: "Client ID of the token to adopt",
+					Required:    true,
+				},
+				"token": {
+					Type:        framework.TypeString,
+					Description: "The token itself, of the form AstraCS:<client ID>:<secret>. Astra never returns it again after creating it, so it must be provided.",
+					Required:    true,
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"role_name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the Vault role to adopt the token under",
+					Required:    true,
+				},
+				"logical_name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Logical name to reference the token by. Required in standard mode, ignored in sidecar mode",
+				},
+				"metadata": {
+					Type:        framework.TypeKVPairs,
+					Description: "Arbitrary key=value",
+				},
+				"ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "TTL of the token's lease. Defaults to the role's ttl, and can't exceed its max_ttl.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathAstraTokensAdopt,
+					Summary:  "Bring a token created outside of Vault under Vault management.",
+				},
+			},
+			HelpSynopsis:    pathAstraTokensAdoptHelpSyn,
+			HelpDescription: pathAstraTokensAdoptHelpDesc,
+		},
+	}
+}
+
+// readOrgTokens reads every token Vault stores for an org, keyed by client ID
+func readOrgTokens(ctx context.Context, s logical.Storage, orgId string) (map[string]*astraToken, error) {
+	keys, err := s.List(ctx, "token/")
+	if err != nil {
+		return nil, errors.New("failed to get token list: " + err.Error())
+	}
+
+	tokens := map[string]*astraToken{}
+	for _, key := range keys {
+		token, err := readToken(ctx, s, key)
+		if err != nil {
+			return nil, err
+		}
+		if token != nil && token.OrgID == orgId {
+			tokens[token.ClientID] = token
+		}
+	}
+
+	return tokens, nil
+}
+
+func (b *datastaxAstraBackend) pathAstraTokensRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	orgIdRaw, ok := d.GetOk("org_id")
+	if !ok {
+		return logical.ErrorResponse("please provide an org_id argument"), nil
+	}
+	orgId := orgIdRaw.(string)
+	config, err := readConfig(ctx, req.Storage, orgId)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("unable to find config for org ID " + orgId), nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage, orgId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+	astraTokens, err := listTokensInAstra(ctx, client)
+	if err != nil {
+		return nil, toVaultError(fmt.Errorf("error listing tokens in astra: %w", err))
+	}
+	managed, err := readOrgTokens(ctx, req.Storage, orgId)
+	if err != nil {
+		return nil, err
+	}
+	rootClientId, _ := clientIdFromToken(config.AstraToken)
+
+	sort.Slice(astraTokens, func(i, j int) bool {
+		return astraTokens[i].ClientID < astraTokens[j].ClientID
+	})
+	tokens := make([]interface{}, 0, len(astraTokens))
+	for _, astraToken := range astraTokens {
+		data := map[string]interface{}{
+			"clientId":    astraToken.ClientID,
+			"roles":       astraToken.Roles,
+			"generatedOn": astraToken.GeneratedOn,
+			"managed":     false,
+			"rootToken":   astraToken.ClientID == rootClientId,
+		}
+		if token, ok := managed[astraToken.ClientID]; ok {
+			data["managed"] = true
+			data["roleName"] = token.RoleName
+			data["logicalName"] = token.LogicalName
+		}
+		tokens = append(tokens, data)
+	}
+
+	return &logical.Response{Data: map[string]interface{}{"tokens": tokens}}, nil
+}
+
+func (b *datastaxAstraBackend) pathAstraTokensAdopt(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	orgId := d.Get("org_id").(string)
+	clientId := d.Get("client_id").(string)
+	tokenValue := d.Get("token").(string)
+	roleName := d.Get("role_name").(string)
+	if orgId == "" || clientId == "" || tokenValue == "" || roleName == "" {
+		return logical.ErrorResponse("please provide org_id, client_id, token and role_name arguments"), nil
+	}
+	tokenClientId, err := clientIdFromToken(tokenValue)
+	if err != nil || tokenClientId != clientId {
+		return logical.ErrorResponse("token is not the token of client ID " + clientId), nil
+	}
+	ttl := time.Duration(d.Get("ttl").(int)) * time.Second
+	if ttl < 0 {
+		return logical.ErrorResponse("ttl must not be negative"), nil
+	}
+
+	config, err := readConfig(ctx, req.Storage, orgId)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("unable to find config for org ID " + orgId), nil
+	}
+	logicalName := d.Get("logical_name").(string)
+	if config.CallerMode == StandardCallerMode && logicalName == "" {
+		return logical.ErrorResponse("please provide a logical_name argument"), nil
+	}
+	if config.CallerMode != StandardCallerMode {
+		logicalName = ""
+	}
+	if rootClientId, _ := clientIdFromToken(config.AstraToken); rootClientId == clientId {
+		return logical.ErrorResponse("client ID " + clientId + " is the root token of org ID " + orgId + " and can't be adopted"), nil
+	}
+
+	roleEntry, err := readRole(ctx, req.Storage, roleName, orgId)
+	if err != nil {
+		return nil, errors.New("error retrieving role " + roleName + ": " + err.Error())
+	}
+	if roleEntry == nil {
+		return logical.ErrorResponse("unable to find role " + roleName), nil
+	}
+	err = b.checkRoleBindings(req, roleEntry)
+	if err != nil {
+		return nil, err
+	}
+	if roleEntry.MaxTTL > 0 && ttl > roleEntry.MaxTTL {
+		return logical.ErrorResponse(fmt.Sprintf("ttl must not exceed the max_ttl of role %s (%s)", roleName, roleEntry.MaxTTL)), nil
+	}
+
+	managed, err := readTokenUsingClientId(ctx, req.Storage, clientId)
+	if err != nil {
+		return nil, err
+	}
+	if managed != nil {
+		return logical.ErrorResponse("client ID " + clientId + " is already managed by Vault as token " + managed.LogicalName + " of role " + managed.RoleName), nil
+	}
+	tokenId := clientId
+	if logicalName != "" {
+		tokenId = calculateTokenId(roleEntry, logicalName)
+		existing, err := readToken(ctx, req.Storage, tokenId)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return logical.ErrorResponse("role " + roleName + " already has a token with logical name " + logicalName), nil
+		}
+	}
+
+	client, err := b.getClient(ctx, req.Storage, orgId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+	astraTokens, err := listTokensInAstra(ctx, client)
+	if err != nil {
+		return nil, toVaultError(fmt.Errorf("error listing tokens in astra: %w", err))
+	}
+	var found *astraClientRoles
+	for i := range astraTokens {
+		if astraTokens[i].ClientID == clientId {
+			found = &astraTokens[i]
+			break
+		}
+	}
+	if found == nil {
+		return logical.ErrorResponse("unable to find client ID " + clientId + " in org ID " + orgId), nil
+	}
+	// The token is handed out to everyone the role admits, so it mustn't grant more than the role does
+	for _, roleId := range found.Roles {
+		if !strutil.StrListContains(roleEntry.RoleIds, roleId) {
+			return logical.ErrorResponse("client ID " + clientId + " has astra role " + roleId + ", which role " + roleName + " doesn't grant"), nil
+		}
+	}
+
+	// Make sure the secret is right, so Vault doesn't hand out a token that doesn't work
+	tokenConfig := *config
+	tokenConfig.AstraToken = tokenValue
+	tokenClient, err := b.clientFactory(&tokenConfig)
+	if err == nil {
+		_, err = tokenClient.GetCurrentOrg(ctx)
+	}
+	if err != nil {
+		return logical.ErrorResponse("unable to verify token of client ID " + clientId + ": " + err.Error()), nil
+	}
+
+	metadata := map[string]string{}
+	if metadataRaw, ok := d.GetOk("metadata"); ok {
+		metadata = metadataRaw.(map[string]string)
+	}
+	token := &astraToken{
+		ClientID:         clientId,
+		OrgID:            orgId,
+		RoleName:         roleName,
+		Roles:            found.Roles,
+		Token:            tokenValue,
+		GeneratedOn:      found.GeneratedOn,
+		LogicalName:      logicalName,
+		Metadata:         metadata,
+		OwnerEntityID:    req.EntityID,
+		OwnerDisplayName: req.DisplayName,
+	}
+	if roleEntry.MaxTTL > 0 {
+		token.MaxExpiry = time.Now().Add(roleEntry.MaxTTL).UTC().Format(time.RFC3339)
+	}
+	err = saveToken(ctx, req.Storage, token, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	b.logger.Info("Adopted token '" + clientId + "' under role " + roleName + " in org ID " + orgId)
+
+	resp, err := b.generateTokenResponse(token, tokenId, roleEntry, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		resp.Secret.TTL = ttl
+	}
+	return resp, nil
+}
+
+const pathAstraTokensHelpSyn = `
+List every token of an organization in Astra.
+`
+
+const pathAstraTokensHelpDesc = `
+This path lists the client ID, roles and creation time of every token of an
+Astra organization, including tokens created outside of Vault, and whether
+Vault manages each of them. The tokens themselves are never returned.
+`
+
+const pathAstraTokensAdoptHelpSyn = `
+Bring a token created outside of Vault under Vault management.
+`
+
+const pathAstraTokensAdoptHelpDesc = `
+This path stores an existing Astra token under a Vault role that grants all of
+its Astra roles and, in standard mode, a logical name, and returns it with a
+lease like the tokens Vault creates. When the lease is revoked or expires, the token is deleted from Astra.
+The token itself must be provided, since Astra only returns it on creation.
+`
@@ -0,0 +1,132 @@
+package datastax_astra
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAstraTokensAdopt makes sure tokens created outside of Vault are listed, and once adopted are
+// managed and revoked like the tokens Vault creates.
+func TestAstraTokensAdopt(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+	config, err := readConfig(ctx, s, org_id)
+	require.NoError(t, err)
+	rootClientId, err := clientIdFromToken(config.AstraToken)
+	require.NoError(t, err)
+	root, ok := server.Token(rootClientId)
+	require.True(t, ok)
+
+	readOnly := server.AddRole("Read Only User")
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/" + org_id + "/app",
+		Storage:   s,
+		Data:      map[string]interface{}{"role_id": readOnly.ID, "read_access": "any", "max_ttl": "2h"},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "creds/" + org_id + "/app",
+		Storage:   s,
+		Data:      map[string]interface{}{"logical_name": "web"},
+	})
+	require.NoError(t, err)
+	issued := resp.Data["clientId"].(string)
+	manual := server.AddToken(readOnly.ID)
+	admin := server.AddToken("adminRoleId")
+
+	listTokens := func() map[string]map[string]interface{} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "org/astra-tokens",
+			Storage:   s,
+			Data:      map[string]interface{}{"org_id": org_id},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "%v", resp)
+		tokens := map[string]map[string]interface{}{}
+		for _, token := range resp.Data["tokens"].([]interface{}) {
+			data := token.(map[string]interface{})
+			require.NotContains(t, data, "token")
+			tokens[data["clientId"].(string)] = data
+		}
+		return tokens
+	}
+	tokens := listTokens()
+	require.Len(t, tokens, 4)
+	require.Equal(t, true, tokens[root.ClientID]["rootToken"])
+	require.Equal(t, true, tokens[issued]["managed"])
+	require.Equal(t, "web", tokens[issued]["logicalName"])
+	require.Equal(t, false, tokens[manual.ClientID]["managed"])
+	require.Equal(t, []string{readOnly.ID}, tokens[manual.ClientID]["roles"])
+
+	adopt := func(data map[string]interface{}) *logical.Response {
+		data["org_id"] = org_id
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "org/astra-tokens/adopt",
+			Storage:   s,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+	// The token must belong to the client ID, the root token can't be adopted and a logical name can't be reused
+	resp = adopt(map[string]interface{}{"client_id": manual.ClientID, "token": root.Token, "role_name": "app", "logical_name": "legacy"})
+	require.True(t, resp.IsError())
+	resp = adopt(map[string]interface{}{"client_id": root.ClientID, "token": root.Token, "role_name": "app", "logical_name": "legacy"})
+	require.True(t, resp.IsError())
+	// A token can only be adopted under a role that grants all of its Astra roles
+	resp = adopt(map[string]interface{}{"client_id": admin.ClientID, "token": admin.Token, "role_name": "app", "logical_name": "admin"})
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "adminRoleId")
+	resp = adopt(map[string]interface{}{"client_id": manual.ClientID, "token": manual.Token, "role_name": "app", "logical_name": "web"})
+	require.True(t, resp.IsError())
+	resp = adopt(map[string]interface{}{"client_id": manual.ClientID, "token": manual.Token, "role_name": "app", "logical_name": "legacy", "ttl": "3h"})
+	require.True(t, resp.IsError())
+
+	resp = adopt(map[string]interface{}{"client_id": manual.ClientID, "token": manual.Token, "role_name": "app", "logical_name": "legacy", "ttl": "30m"})
+	require.False(t, resp.IsError(), "%v", resp)
+	require.NotNil(t, resp.Secret)
+	require.Equal(t, 30*time.Minute, resp.Secret.TTL)
+	require.Equal(t, manual.Token, resp.Data["token"])
+	require.Equal(t, true, listTokens()[manual.ClientID]["managed"])
+	resp = adopt(map[string]interface{}{"client_id": manual.ClientID, "token": manual.Token, "role_name": "app", "logical_name": "other"})
+	require.True(t, resp.IsError())
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/" + org_id + "/app",
+		Storage:   s,
+		Data:      map[string]interface{}{"logical_name": "legacy"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, manual.Token, resp.Data["token"])
+
+	// Revoking the lease deletes the adopted token from Astra
+	roleEntry, err := readRole(ctx, s, "app", org_id)
+	require.NoError(t, err)
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret: &logical.Secret{InternalData: map[string]interface{}{
+			"secret_type": astraTokenType,
+			"orgId":       org_id,
+			"clientId":    manual.ClientID,
+			"roleName":    "app",
+			"tokenId":     calculateTokenId(roleEntry, "legacy"),
+		}},
+	})
+	require.NoError(t, err)
+	_, ok = server.Token(manual.ClientID)
+	require.False(t, ok)
+	token, err := readToken(ctx, s, calculateTokenId(roleEntry, "legacy"))
+	require.NoError(t, err)
+	require.Nil(t, token)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"time"
 )
 
 const (
@@ -61,6 +62,169 @@ func pathConfig(b *datastaxAstraBackend) *framework.Path {
 					Sensitive: false,
 				},
 			},
+			"skip_verification": {
+				Type:        framework.TypeBool,
+				Description: "Skip checking the astra_token and org_id against Astra when writing the config. Useful when Astra is not reachable at config time.",
+				Required:    false,
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "skip_verification",
+					Sensitive: false,
+				},
+			},
+			"tls_ca_cert": {
+				Type:        framework.TypeString,
+				Description: "PEM encoded CA certificate bundle used to verify the Astra API, e.g. for a TLS intercepting proxy. Defaults to the system CA bundle.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "tls_ca_cert",
+					Sensitive: false,
+				},
+			},
+			"tls_skip_verify": {
+				Type:        framework.TypeBool,
+				Description: "Skip verifying the Astra API's TLS certificate. Only meant for lab setups.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "tls_skip_verify",
+					Sensitive: false,
+				},
+			},
+			"proxy_url": {
+				Type:        framework.TypeString,
+				Description: "URL of the proxy to send Astra API requests through. Defaults to the HTTP_PROXY/HTTPS_PROXY environment variables.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "proxy_url",
+					Sensitive: true,
+				},
+			},
+			"request_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Timeout for each request to the Astra API. If unset or set to 0, it will default to 30 seconds.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "request_timeout",
+					Sensitive: false,
+				},
+			},
+			"max_idle_conns": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of idle connections to the Astra API kept open. If unset or set to 0, the Go default is used.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "max_idle_conns",
+					Sensitive: false,
+				},
+			},
+			"max_idle_conns_per_host": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of idle connections kept open per Astra API host. If unset or set to 0, the Go default is used.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "max_idle_conns_per_host",
+					Sensitive: false,
+				},
+			},
+			"idle_conn_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long an idle connection to the Astra API is kept open. If unset or set to 0, the Go default is used.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "idle_conn_timeout",
+					Sensitive: false,
+				},
+			},
+			"disable_keep_alives": {
+				Type:        framework.TypeBool,
+				Description: "Open a new connection for every request to the Astra API.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "disable_keep_alives",
+					Sensitive: false,
+				},
+			},
+			"max_retries": {
+				Type:        framework.TypeInt,
+				Description: "How many times a failed Astra API request is retried. Token creation is only retried when Astra rate limits it. Defaults to 3 for new configs; set to 0 to disable retries.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "max_retries",
+					Sensitive: false,
+				},
+			},
+			"retry_min_wait": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Minimum time to wait before retrying a failed Astra API request. If unset or set to 0, it will default to 1 second.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "retry_min_wait",
+					Sensitive: false,
+				},
+			},
+			"retry_max_wait": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Maximum time to wait before retrying a failed Astra API request, including waits requested by Astra through Retry-After. If unset or set to 0, it will default to 30 seconds.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "retry_max_wait",
+					Sensitive: false,
+				},
+			},
+			"rotation_period": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How often the astra_token is automatically rotated, e.g. 720h. If unset or set to 0, the token is never rotated automatically.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "rotation_period",
+					Sensitive: false,
+				},
+			},
+			"rotation_window": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional window after each rotation_period within which the rotation is scheduled at random, so several orgs do not rotate at once.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "rotation_window",
+					Sensitive: false,
+				},
+			},
+			"tidy_interval": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How often the org's tokens in Vault are automatically reconciled with those in Astra, e.g. 24h. If unset or set to 0, they are only reconciled through the tidy endpoint.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "tidy_interval",
+					Sensitive: false,
+				},
+			},
+			"tidy_delete_stale_entries": {
+				Type:        framework.TypeBool,
+				Description: "Whether the scheduled tidy deletes the Vault entries of tokens that no longer exist in Astra.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "tidy_delete_stale_entries",
+					Sensitive: false,
+				},
+			},
+			"tidy_revoke_untracked": {
+				Type:        framework.TypeBool,
+				Description: "Whether the scheduled tidy revokes the Astra tokens Vault created but no longer tracks.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "tidy_revoke_untracked",
+					Sensitive: false,
+				},
+			},
+			"tidy_delete_orphaned_roles": {
+				Type:        framework.TypeBool,
+				Description: "Whether the scheduled tidy deletes the temporary Astra roles Vault created for tokens that no longer exist.",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "tidy_delete_orphaned_roles",
+					Sensitive: false,
+				},
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
@@ -82,47 +246,56 @@ func pathConfig(b *datastaxAstraBackend) *framework.Path {
 	}
 }
 
-// pathConfigRead reads the configuration and outputs information.
+// pathConfigRead reads the configuration and outputs information. The astra_token is left out; it can
+//  only be read through config/reveal.
 func (b *datastaxAstraBackend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, resp, err := readConfigFromRequest(ctx, req.Storage, data)
+	if config == nil {
+		return resp, err
+	}
+	return &logical.Response{
+		Data: config.ToResponseData(),
+	}, nil
+}
+
+// readConfigFromRequest reads the config a request refers to by its org_id or logical_name argument. If no
+//  config is returned, the response or error should be returned to the caller instead.
+func readConfigFromRequest(ctx context.Context, s logical.Storage, data *framework.FieldData) (*astraConfig, *logical.Response, error) {
 	orgId := data.Get("org_id").(string)
 	logicalName := data.Get("logical_name").(string)
 	if logicalName != "" && orgId == "" {
-		configs, err := listConfig(ctx, req.Storage)
+		configs, err := listConfig(ctx, s)
 		if err != nil {
-			return nil, errors.New("error retrieving configs: " + err.Error())
+			return nil, nil, errors.New("error retrieving configs: " + err.Error())
 		}
 		if len(configs) == 0 {
-			return nil, errors.New("no configs found")
+			return nil, nil, errors.New("no configs found")
 		}
 		for _, orgId := range configs {
-			m, err := readConfig(ctx, req.Storage, orgId)
+			m, err := readConfig(ctx, s, orgId)
 			if err != nil {
-				return nil, errors.New("error retrieving config for org ID " + orgId + ": " + err.Error())
+				return nil, nil, errors.New("error retrieving config for org ID " + orgId + ": " + err.Error())
 			}
 			if m == nil {
-				return nil, errors.New("unable to find config for org ID " + orgId)
+				return nil, nil, errors.New("unable to find config for org ID " + orgId)
 			}
 			if m.LogicalName == logicalName {
-				return &logical.Response{
-					Data: m.ToResponseData(),
-				}, nil
+				return m, nil, nil
 			}
 		}
-		return nil, errors.New("unable to find config for logical name " + logicalName)
+		return nil, nil, errors.New("unable to find config for logical name " + logicalName)
 	}
 	if orgId != "" {
-		config, err := readConfig(ctx, req.Storage, orgId)
+		config, err := readConfig(ctx, s, orgId)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if config == nil {
-			return nil, errors.New("unable to find config for org ID " + orgId)
+			return nil, nil, errors.New("unable to find config for org ID " + orgId)
 		}
-		return &logical.Response{
-			Data: config.ToResponseData(),
-		}, nil
+		return config, nil, nil
 	}
-	return logical.ErrorResponse("please provide an org_id or a logical_name argument"), nil
+	return nil, logical.ErrorResponse("please provide an org_id or a logical_name argument"), nil
 }
 
 // pathConfigWrite updates the configuration for the backend
@@ -148,18 +321,88 @@ func (b *datastaxAstraBackend) pathConfigWrite(ctx context.Context, req *logical
 		config.OrgId = orgId
 	}
 
-	token, ok := data.GetOk("astra_token")
-	if ok {
+	token, tokenOk := data.GetOk("astra_token")
+	if tokenOk {
 		config.AstraToken = token.(string)
-	} else if !ok && createOperation {
+	} else if createOperation {
 		return logical.ErrorResponse("please provide an astra_token argument"), nil
 	}
-	url, ok := data.GetOk("url")
-	if ok {
+	url, urlOk := data.GetOk("url")
+	if urlOk {
 		config.URL = url.(string)
-	} else if !ok && createOperation {
+	} else if createOperation {
 		return logical.ErrorResponse("please provide a url argument"), nil
 	}
+	tlsCACert, ok := data.GetOk("tls_ca_cert")
+	if ok {
+		config.TLSCACert = tlsCACert.(string)
+	}
+	tlsSkipVerify, ok := data.GetOk("tls_skip_verify")
+	if ok {
+		config.TLSSkipVerify = tlsSkipVerify.(bool)
+	}
+	proxyURL, ok := data.GetOk("proxy_url")
+	if ok {
+		config.ProxyURL = proxyURL.(string)
+	}
+	requestTimeout, ok := data.GetOk("request_timeout")
+	if ok {
+		config.RequestTimeout = time.Duration(requestTimeout.(int)) * time.Second
+	}
+	maxIdleConns, ok := data.GetOk("max_idle_conns")
+	if ok {
+		config.MaxIdleConns = maxIdleConns.(int)
+	}
+	maxIdleConnsPerHost, ok := data.GetOk("max_idle_conns_per_host")
+	if ok {
+		config.MaxIdleConnsPerHost = maxIdleConnsPerHost.(int)
+	}
+	idleConnTimeout, ok := data.GetOk("idle_conn_timeout")
+	if ok {
+		config.IdleConnTimeout = time.Duration(idleConnTimeout.(int)) * time.Second
+	}
+	disableKeepAlives, ok := data.GetOk("disable_keep_alives")
+	if ok {
+		config.DisableKeepAlives = disableKeepAlives.(bool)
+	}
+	if config.RequestTimeout < 0 || config.MaxIdleConns < 0 || config.MaxIdleConnsPerHost < 0 || config.IdleConnTimeout < 0 {
+		return logical.ErrorResponse("request_timeout, max_idle_conns, max_idle_conns_per_host and idle_conn_timeout must not be negative"), nil
+	}
+	maxRetries, ok := data.GetOk("max_retries")
+	if ok {
+		if maxRetries.(int) < 0 {
+			return logical.ErrorResponse("max_retries must not be negative"), nil
+		}
+		config.MaxRetries = maxRetries.(int)
+	} else if createOperation {
+		config.MaxRetries = defaultMaxRetries
+	}
+	retryMinWait, ok := data.GetOk("retry_min_wait")
+	if ok {
+		config.RetryMinWait = time.Duration(retryMinWait.(int)) * time.Second
+	}
+	retryMaxWait, ok := data.GetOk("retry_max_wait")
+	if ok {
+		config.RetryMaxWait = time.Duration(retryMaxWait.(int)) * time.Second
+	}
+	if config.RetryMinWait < 0 || config.RetryMaxWait < 0 {
+		return logical.ErrorResponse("retry_min_wait and retry_max_wait must not be negative"), nil
+	}
+	if config.RetryMinWait > 0 && config.RetryMaxWait > 0 && config.RetryMinWait > config.RetryMaxWait {
+		return logical.ErrorResponse("retry_min_wait must not be greater than retry_max_wait"), nil
+	}
+
+	// Building the client validates the transport settings
+	client, err := b.clientFactory(config)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if (tokenOk || urlOk) && !data.Get("skip_verification").(bool) {
+		err = verifyClient(ctx, client, config.OrgId)
+		if err != nil {
+			return logical.ErrorResponse("unable to verify astra_token for org ID " + config.OrgId + ": " + err.Error()), nil
+		}
+	}
 	logicalName, ok := data.GetOk("logical_name")
 	if ok {
 		config.LogicalName = logicalName.(string)
@@ -174,6 +417,44 @@ func (b *datastaxAstraBackend) pathConfigWrite(ctx context.Context, req *logical
 	} else if !ok && createOperation {
 		config.CallerMode = StandardCallerMode
 	}
+	rotationWindow, ok := data.GetOk("rotation_window")
+	if ok {
+		if rotationWindow.(int) < 0 {
+			return logical.ErrorResponse("rotation_window must not be negative"), nil
+		}
+		config.RotationWindow = time.Duration(rotationWindow.(int)) * time.Second
+	}
+	rotationPeriod, ok := data.GetOk("rotation_period")
+	if ok {
+		if rotationPeriod.(int) < 0 {
+			return logical.ErrorResponse("rotation_period must not be negative"), nil
+		}
+		period := time.Duration(rotationPeriod.(int)) * time.Second
+		if period != config.RotationPeriod || config.NextRotation.IsZero() {
+			config.RotationPeriod = period
+			config.scheduleNextRotation(time.Now())
+		}
+	}
+
+	tidyInterval, ok := data.GetOk("tidy_interval")
+	if ok {
+		if tidyInterval.(int) < 0 {
+			return logical.ErrorResponse("tidy_interval must not be negative"), nil
+		}
+		config.TidyInterval = time.Duration(tidyInterval.(int)) * time.Second
+	}
+	tidyDeleteStaleEntries, ok := data.GetOk("tidy_delete_stale_entries")
+	if ok {
+		config.TidyDeleteStaleEntries = tidyDeleteStaleEntries.(bool)
+	}
+	tidyRevokeUntracked, ok := data.GetOk("tidy_revoke_untracked")
+	if ok {
+		config.TidyRevokeUntracked = tidyRevokeUntracked.(bool)
+	}
+	tidyDeleteOrphanedRoles, ok := data.GetOk("tidy_delete_orphaned_roles")
+	if ok {
+		config.TidyDeleteOrphanedRoles = tidyDeleteOrphanedRoles.(bool)
+	}
 
 	err = saveConfig(ctx, config, req.Storage)
 	if err != nil {
@@ -185,8 +466,8 @@ func (b *datastaxAstraBackend) pathConfigWrite(ctx context.Context, req *logical
 		operationToStringVerb(req.Operation),
 		config.OrgId,
 		config.CallerMode.String()))
-	// reset the client so the next invocation will pick up the new configuration
-	b.reset()
+	// reset the org's client so the next invocation will pick up the new configuration
+	b.resetClient(config.OrgId)
 	return nil, nil
 }
 
@@ -236,7 +517,7 @@ func (b *datastaxAstraBackend) pathConfigDelete(ctx context.Context, req *logica
 		return nil, err
 	}
 	b.logger.Info("Deleted config for org ID " + orgId.(string))
-	b.reset()
+	b.resetClient(orgId.(string))
 	return nil, nil
 }
 
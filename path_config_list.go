@@ -14,6 +14,7 @@ const (
 func pathConfigList(b *datastaxAstraBackend) *framework.Path {
     return &framework.Path{
         Pattern: "configs/?$",
+        Fields:  PathList(ConfigPathList).listFields(),
         Operations: map[logical.Operation]framework.OperationHandler{
             logical.ListOperation: &framework.PathOperation{
                 Callback: b.pathConfigList,
@@ -26,5 +27,5 @@ func pathConfigList(b *datastaxAstraBackend) *framework.Path {
 }
 
 func (b *datastaxAstraBackend) pathConfigList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-    return b.pathObjectList(ctx, req, ConfigPathList)
+    return b.pathObjectList(ctx, req, d, ConfigPathList)
 }
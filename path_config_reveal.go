@@ -0,0 +1,65 @@
+package datastax_astra
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathConfigReveal extends the Vault API with a `/config/reveal` endpoint that, unlike `/config`, returns the
+// astra_token. Being its own path, it can be granted to fewer Vault policies than reading the config.
+func pathConfigReveal(b *datastaxAstraBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/reveal",
+		Fields: map[string]*framework.FieldSchema{
+			"org_id": {
+				Type:        framework.TypeString,
+				Description: "UUID of organization",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "org_id",
+					Sensitive: false,
+				},
+			},
+			"logical_name": {
+				Type:        framework.TypeString,
+				Description: "logical name of config",
+				Required:    false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "logical_name",
+					Sensitive: false,
+				},
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigRevealRead,
+				Summary:  "Read the configuration of an organization, including its astra_token.",
+			},
+		},
+		HelpSynopsis:    pathConfigRevealHelpSynopsis,
+		HelpDescription: pathConfigRevealHelpDescription,
+	}
+}
+
+func (b *datastaxAstraBackend) pathConfigRevealRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, resp, err := readConfigFromRequest(ctx, req.Storage, data)
+	if config == nil {
+		return resp, err
+	}
+
+	b.logger.Info("Revealed astra_token of org ID " + config.OrgId + " to " + req.DisplayName)
+	return &logical.Response{
+		Data: config.ToRevealedResponseData(),
+	}, nil
+}
+
+const (
+	pathConfigRevealHelpSynopsis    = `Reads a configuration including its Astra token.`
+	pathConfigRevealHelpDescription = `
+This path returns the same configuration as the config path, found by 'org_id'
+or 'logical_name', along with the 'astra_token' that config reads leave out.
+Grant it only to the Vault policies that need the root token itself.
+`
+)
@@ -0,0 +1,275 @@
+package datastax_astra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	astraTokenPrefix         = "AstraCS"
+	astraTokenPartsDelimiter = ":"
+	rotationRetryMinWait     = time.Minute
+	rotationRetryMaxWait     = time.Hour
+)
+
+// rotationBackoff tracks failed automatic rotations of an org's root token
+type rotationBackoff struct {
+	failures int
+	retryAt  time.Time
+}
+
+func pathConfigRotateRoot(b *datastaxAstraBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/rotate-root",
+		Fields: map[string]*framework.FieldSchema{
+			"org_id": {
+				Type:        framework.TypeString,
+				Description: "UUID of organization whose root credential should be rotated",
+				Required:    true,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "org_id",
+					Sensitive: false,
+				},
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigRotateRootUpdate,
+				Summary:  "Rotate the Astra token used to manage tokens for the organization.",
+			},
+		},
+		HelpSynopsis:    pathConfigRotateRootHelpSynopsis,
+		HelpDescription: pathConfigRotateRootHelpDescription,
+	}
+}
+
+// pathConfigRotateRootUpdate replaces the configured Astra token with a newly minted one
+func (b *datastaxAstraBackend) pathConfigRotateRootUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	orgIdRaw, ok := data.GetOk("org_id")
+	if !ok {
+		return logical.ErrorResponse("please provide an org_id argument"), nil
+	}
+	orgId := orgIdRaw.(string)
+
+	newClientId, err := b.rotateRootToken(ctx, req.Storage, orgId)
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"org_id":    orgId,
+			"client_id": newClientId,
+		},
+	}
+	if err != nil {
+		if newClientId == "" {
+			return nil, toVaultError(err)
+		}
+		// The new token is already in use, only revoking the previous one failed
+		resp.AddWarning(err.Error())
+	}
+
+	return resp, nil
+}
+
+// rotateRootToken mints a new Astra token with the same roles as the configured one, stores it
+// in the org's config and revokes the previous token. The new client ID is returned once the
+// new token has been saved, even if revoking the previous token fails afterwards.
+func (b *datastaxAstraBackend) rotateRootToken(ctx context.Context, s logical.Storage, orgId string) (string, error) {
+	b.rotationLock.Lock()
+	defer b.rotationLock.Unlock()
+
+	config, err := readConfig(ctx, s, orgId)
+	if err != nil {
+		return "", err
+	}
+	if config == nil {
+		return "", errors.New("unable to find config for org ID " + orgId)
+	}
+	oldClientId, err := clientIdFromToken(config.AstraToken)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := b.getClient(ctx, s, orgId)
+	if err != nil {
+		return "", fmt.Errorf("error getting client: %w", err)
+	}
+	clients, err := listTokensInAstra(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("error listing tokens in astra: %w", err)
+	}
+	var roles []string
+	for _, c := range clients {
+		if c.ClientID == oldClientId {
+			roles = c.Roles
+			break
+		}
+	}
+	if len(roles) == 0 {
+		return "", errors.New("unable to find roles for client ID " + oldClientId + " in astra")
+	}
+
+	newToken, err := createTokenWithRolesInAstra(ctx, client, roles)
+	if err != nil {
+		return "", fmt.Errorf("error creating Astra token: %w", err)
+	}
+
+	// Make sure the new token works before we throw the old one away
+	newConfig := *config
+	newConfig.AstraToken = newToken.Token
+	newConfig.LastRotated = time.Now()
+	newConfig.scheduleNextRotation(newConfig.LastRotated)
+	newClient, err := b.clientFactory(&newConfig)
+	if err == nil {
+		_, err = listTokensInAstra(ctx, newClient)
+	}
+	if err != nil {
+		if delErr := deleteTokenFromAstra(context.Background(), client, newToken.ClientID); delErr != nil {
+			b.logger.Error("Failed to clean up unusable token " + newToken.ClientID + ": " + delErr.Error())
+		}
+		return "", fmt.Errorf("unable to verify new Astra token: %w", err)
+	}
+
+	err = saveConfig(ctx, &newConfig, s)
+	if err != nil {
+		if delErr := deleteTokenFromAstra(context.Background(), client, newToken.ClientID); delErr != nil {
+			b.logger.Error("Failed to clean up unsaved token " + newToken.ClientID + ": " + delErr.Error())
+		}
+		return "", err
+	}
+	b.resetClient(orgId)
+	b.logger.Info("Rotated root token for org ID " + orgId + "; new client ID is " + newToken.ClientID)
+
+	err = deleteTokenFromAstra(ctx, newClient, oldClientId)
+	if err != nil {
+		errMsg := "error revoking previous root token " + oldClientId + ": " + err.Error()
+		// Keep the previous client ID so that revoking it is retried by the next periodic run
+		newConfig.PendingRootClientIds = append(newConfig.PendingRootClientIds, oldClientId)
+		if saveErr := saveConfig(ctx, &newConfig, s); saveErr != nil {
+			errMsg += "; unable to record it for a later retry: " + saveErr.Error()
+		}
+		b.logger.Error(errMsg)
+		return newToken.ClientID, errors.New(errMsg)
+	}
+
+	return newToken.ClientID, nil
+}
+
+// revokePendingRootTokens retries revoking the previous root tokens of an org that could not be
+// revoked when they were rotated, and forgets those that are now gone from Astra.
+func (b *datastaxAstraBackend) revokePendingRootTokens(ctx context.Context, s logical.Storage, orgId string) error {
+	b.rotationLock.Lock()
+	defer b.rotationLock.Unlock()
+
+	config, err := readConfig(ctx, s, orgId)
+	if err != nil {
+		return err
+	}
+	if config == nil || len(config.PendingRootClientIds) == 0 {
+		return nil
+	}
+	client, err := b.getClient(ctx, s, orgId)
+	if err != nil {
+		return fmt.Errorf("error getting client: %w", err)
+	}
+
+	var pending []string
+	var errs []string
+	for _, clientId := range config.PendingRootClientIds {
+		err = deleteTokenFromAstra(ctx, client, clientId)
+		if err != nil {
+			pending = append(pending, clientId)
+			errs = append(errs, "error revoking previous root token "+clientId+": "+err.Error())
+			continue
+		}
+		b.logger.Info("Revoked previous root token " + clientId + " for org ID " + orgId)
+	}
+	if len(pending) < len(config.PendingRootClientIds) {
+		config.PendingRootClientIds = pending
+		err = saveConfig(ctx, config, s)
+		if err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// rotateDueRootTokens is run periodically and rotates the root token of every org whose
+// rotation_period has elapsed. Failed rotations are retried with an exponential backoff.
+func (b *datastaxAstraBackend) rotateDueRootTokens(ctx context.Context, req *logical.Request) error {
+	orgIds, err := req.Storage.List(ctx, configStoragePath)
+	if err != nil {
+		return errors.New("error loading config list: " + err.Error())
+	}
+
+	now := time.Now()
+	for _, orgId := range orgIds {
+		config, err := readConfig(ctx, req.Storage, orgId)
+		if err != nil {
+			b.logger.Error("Unable to check root token rotation for org ID " + orgId + ": " + err.Error())
+			continue
+		}
+		if config != nil && len(config.PendingRootClientIds) > 0 {
+			err = b.revokePendingRootTokens(ctx, req.Storage, orgId)
+			if err != nil {
+				b.logger.Error("Unable to revoke previous root tokens for org ID " + orgId + ": " + err.Error())
+			}
+		}
+		if config == nil || config.RotationPeriod <= 0 || config.NextRotation.IsZero() || now.Before(config.NextRotation) {
+			continue
+		}
+		if backoff, ok := b.rotationBackoffs[orgId]; ok && now.Before(backoff.retryAt) {
+			continue
+		}
+
+		newClientId, err := b.rotateRootToken(ctx, req.Storage, orgId)
+		if err != nil && newClientId == "" {
+			backoff, ok := b.rotationBackoffs[orgId]
+			if !ok {
+				backoff = &rotationBackoff{}
+				b.rotationBackoffs[orgId] = backoff
+			}
+			wait := rotationRetryMaxWait
+			if backoff.failures < 6 {
+				wait = rotationRetryMinWait << backoff.failures
+			}
+			if wait > rotationRetryMaxWait {
+				wait = rotationRetryMaxWait
+			}
+			backoff.failures++
+			backoff.retryAt = now.Add(wait)
+			b.logger.Error(fmt.Sprintf(
+				"Scheduled rotation of root token for org ID %s failed (attempt %d); retrying in %s: %s",
+				orgId, backoff.failures, wait, err.Error()))
+			continue
+		}
+		delete(b.rotationBackoffs, orgId)
+	}
+
+	return nil
+}
+
+// clientIdFromToken extracts the client ID from an Astra token of the form AstraCS:<clientId>:<secret hash>
+func clientIdFromToken(token string) (string, error) {
+	parts := strings.Split(token, astraTokenPartsDelimiter)
+	if len(parts) != 3 || parts[0] != astraTokenPrefix || parts[1] == "" {
+		return "", errors.New("unable to determine client ID from the configured astra_token")
+	}
+	return parts[1], nil
+}
+
+const pathConfigRotateRootHelpSynopsis = `Rotate the Astra token configured for an organization.`
+
+const pathConfigRotateRootHelpDescription = `
+This path mints a new Astra token with the same roles as the configured
+astra_token, checks that it works, stores it in the organization's config
+and revokes the previous token. The new token is never returned, so once
+rotated the root credential is only known to Vault.`
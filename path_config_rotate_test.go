@@ -0,0 +1,143 @@
+package datastax_astra
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/datastax/vault-plugin-secrets-datastax-astra/astratest"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigRotateRoot(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := astratest.NewServer(org_id)
+	defer server.Close()
+	original := server.AddToken("adminRoleId")
+
+	err := testConfigCreate(t, b, s, map[string]interface{}{
+		"astra_token": original.Token,
+		"url":         server.URL,
+		"org_id":      org_id,
+	})
+	require.NoError(t, err)
+
+	// A failing Astra API is retried while listing the old token's roles
+	server.InjectFailure(astratest.Failure{Method: http.MethodGet, PathPrefix: astratest.SecretsPath, StatusCode: http.StatusServiceUnavailable, RetryAfter: "0", Count: 1})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate-root",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"org_id": org_id,
+		},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	require.Empty(t, resp.Warnings)
+	require.NotContains(t, resp.Data, "astra_token")
+	rotatedId := resp.Data["client_id"].(string)
+	require.Equal(t, []string{rotatedId}, server.ClientIDs())
+	rotated, _ := server.Token(rotatedId)
+	require.Equal(t, []string{"adminRoleId"}, rotated.Roles)
+
+	config, err := readConfig(context.Background(), s, org_id)
+	require.NoError(t, err)
+	require.Equal(t, rotated.Token, config.AstraToken)
+
+	client, err := b.getClient(context.Background(), s, org_id)
+	require.NoError(t, err)
+	require.Equal(t, config.AstraToken, client.(*astraClient).token)
+}
+
+func TestConfigScheduledRotation(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	server := astratest.NewServer(org_id)
+	defer server.Close()
+	original := server.AddToken("adminRoleId")
+
+	err := testConfigCreate(t, b, s, map[string]interface{}{
+		"astra_token":     original.Token,
+		"url":             server.URL,
+		"org_id":          org_id,
+		"rotation_period": "720h",
+	})
+	require.NoError(t, err)
+
+	config, err := readConfig(ctx, s, org_id)
+	require.NoError(t, err)
+	require.Equal(t, 720*time.Hour, config.RotationPeriod)
+	require.WithinDuration(t, time.Now().Add(720*time.Hour), config.NextRotation, time.Minute)
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   s,
+		Data:      map[string]interface{}{"org_id": org_id},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(720*60*60), resp.Data["rotation_period"])
+
+	// Nothing is due yet
+	require.NoError(t, b.periodicFunc(ctx, &logical.Request{Storage: s}))
+	require.Equal(t, []string{original.ClientID}, server.ClientIDs())
+
+	config.NextRotation = time.Now().Add(-time.Minute)
+	require.NoError(t, saveConfig(ctx, config, s))
+	require.NoError(t, b.periodicFunc(ctx, &logical.Request{Storage: s}))
+	clientIds := server.ClientIDs()
+	require.Len(t, clientIds, 1)
+	require.NotEqual(t, original.ClientID, clientIds[0])
+
+	config, err = readConfig(ctx, s, org_id)
+	require.NoError(t, err)
+	rotated, _ := server.Token(clientIds[0])
+	require.Equal(t, rotated.Token, config.AstraToken)
+	require.WithinDuration(t, time.Now(), config.LastRotated, time.Minute)
+	require.Equal(t, config.LastRotated.Add(720*time.Hour), config.NextRotation)
+
+	// A failed rotation is not retried until its backoff has elapsed
+	config.NextRotation = time.Now().Add(-time.Minute)
+	config.AstraToken = "AstraCS:unknown:secret"
+	require.NoError(t, saveConfig(ctx, config, s))
+	b.resetClient(org_id)
+	require.NoError(t, b.periodicFunc(ctx, &logical.Request{Storage: s}))
+	require.Equal(t, 1, b.rotationBackoffs[org_id].failures)
+	require.NoError(t, b.periodicFunc(ctx, &logical.Request{Storage: s}))
+	require.Equal(t, 1, b.rotationBackoffs[org_id].failures)
+}
+
+func TestConfigRotateRootRevokeFailure(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+	original := server.ClientIDs()
+	require.Len(t, original, 1)
+
+	// Revoking the previous root token fails once the new one is in use
+	server.InjectFailure(astratest.Failure{Method: http.MethodDelete, PathPrefix: astratest.SecretsPath, StatusCode: http.StatusForbidden, Count: 1})
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate-root",
+		Storage:   s,
+		Data:      map[string]interface{}{"org_id": org_id},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	require.Len(t, resp.Warnings, 1)
+	rotatedId := resp.Data["client_id"].(string)
+	require.ElementsMatch(t, []string{original[0], rotatedId}, server.ClientIDs())
+
+	config, err := readConfig(ctx, s, org_id)
+	require.NoError(t, err)
+	require.Equal(t, original, config.PendingRootClientIds)
+
+	// The next periodic run revokes it, and forgets it once it is gone
+	require.NoError(t, b.periodicFunc(ctx, &logical.Request{Storage: s}))
+	require.Equal(t, []string{rotatedId}, server.ClientIDs())
+	config, err = readConfig(ctx, s, org_id)
+	require.NoError(t, err)
+	require.Empty(t, config.PendingRootClientIds)
+}
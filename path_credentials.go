@@ -5,68 +5,122 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"time"
+
+	dsAstraClient "github.com/datastax/astra-client-go/v2/astra"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
-// pathCredentials extends the Vault API with a `/token` endpoint for a role.
-func pathCredentials(b *datastaxAstraBackend) *framework.Path {
-	return &framework.Path{
-		Pattern: "org/token",
-		Fields: map[string]*framework.FieldSchema{
-			"org_id": {
-				Type:        framework.TypeString,
-				Description: "name of the org for which token is being requested",
-				Required:    true,
-				DisplayAttrs: &framework.DisplayAttributes{
-					Sensitive: false,
-				},
-			},
-			"role_name": {
-				Type:        framework.TypeLowerCaseString,
-				Description: "name of the role for which token is being requested",
-				Required:    false,
-				DisplayAttrs: &framework.DisplayAttributes{
-					Sensitive: false,
-				},
-			},
-			"logical_name": {
-				Type:        framework.TypeLowerCaseString,
-				Description: "Logical name to reference this token by. Ignored if running in sidecar mode",
-				Required:    false,
-				DisplayAttrs: &framework.DisplayAttributes{
-					Sensitive: false,
-				},
-			},
-			"metadata": {
-				Type:         framework.TypeKVPairs,
-				Description:  "Arbitrary key=value",
-				Required:     false,
-				DisplayAttrs: &framework.DisplayAttributes{Sensitive: false},
+// pathCredentials extends the Vault API with a `/token` endpoint for a role, which takes the org ID and role name
+// as arguments, and `/creds/<org_id>/<role_name>`, which takes them from the path so ACL policies can be scoped to them.
+func pathCredentials(b *datastaxAstraBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern:         "org/token",
+			Fields:          credentialsFields(),
+			Operations:      credentialsOperations(b),
+			HelpSynopsis:    pathCredentialsHelpSyn,
+			HelpDescription: pathCredentialsHelpDesc,
+		},
+		{
+			Pattern:         "creds/" + framework.GenericNameRegex("org_id") + "/" + framework.GenericNameRegex("role_name"),
+			Fields:          credentialsFields(),
+			Operations:      credentialsOperations(b),
+			HelpSynopsis:    pathCredentialsHelpSyn,
+			HelpDescription: pathCredentialsHelpDesc,
+		},
+	}
+}
+
+func credentialsFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"org_id": {
+			Type:        framework.TypeString,
+			Description: "name of the org for which token is being requested",
+			Required:    true,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Sensitive: false,
 			},
-			"client_id": {
-				Type:        framework.TypeString,
-				Description: "ClientId for the token. Ignored if running in sidecar mode",
-				Required:    false,
-				DisplayAttrs: &framework.DisplayAttributes{
-					Sensitive: false,
-				},
+		},
+		"role_name": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "name of the role for which token is being requested",
+			Required:    false,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Sensitive: false,
 			},
 		},
-		Operations: map[logical.Operation]framework.OperationHandler{
-			logical.ReadOperation: &framework.PathOperation{
-				Callback: b.pathCredentialsRead,
+		"logical_name": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "Logical name to reference this token by. Ignored if running in sidecar mode",
+			Required:    false,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Sensitive: false,
 			},
-			logical.UpdateOperation: &framework.PathOperation{
-				Callback: b.pathCredentialsUpdate,
+		},
+		"metadata": {
+			Type:         framework.TypeKVPairs,
+			Description:  "Arbitrary key=value",
+			Required:     false,
+			DisplayAttrs: &framework.DisplayAttributes{Sensitive: false},
+		},
+		"client_id": {
+			Type:        framework.TypeString,
+			Description: "ClientId for the token. Ignored if running in sidecar mode",
+			Required:    false,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Sensitive: false,
 			},
 		},
-		HelpSynopsis:    pathCredentialsHelpSyn,
-		HelpDescription: pathCredentialsHelpDesc,
+		"policy": {
+			Type:        framework.TypeString,
+			Description: "Inline Astra policy document as JSON. It is narrowed down to the role's allowed_actions and allowed_resources, and the token gets a temporary custom role with the result instead of the role's Astra roles. The custom role is deleted when the token is revoked.",
+			Required:    false,
+		},
+	}
+}
+
+func credentialsOperations(b *datastaxAstraBackend) map[logical.Operation]framework.OperationHandler {
+	return map[logical.Operation]framework.OperationHandler{
+		logical.ReadOperation: &framework.PathOperation{
+			Callback: b.pathCredentialsRead,
+		},
+		logical.UpdateOperation: &framework.PathOperation{
+			Callback: b.pathCredentialsUpdate,
+		},
+		logical.DeleteOperation: &framework.PathOperation{
+			Callback: b.pathCredentialsDelete,
+			Summary:  "Revoke a token by its client_id, or by its role_name and logical_name.",
+		},
 	}
 }
 
 func readTokenUsingClientId(ctx context.Context, s logical.Storage, clientId string) (*astraToken, error) {
+	index, err := readTokenIndex(ctx, s, clientId)
+	if err != nil {
+		return nil, errors.New("unable to retrieve token index: " + err.Error())
+	}
+	if index != nil {
+		token, err := readToken(ctx, s, index.TokenID)
+		if err != nil {
+			return nil, errors.New("unable to retrieve token information: " + err.Error())
+		}
+		// The token's entry may since have been reused by a token with the same logical name
+		if token != nil && token.ClientID == clientId {
+			return token, nil
+		}
+		return nil, nil
+	}
+	backfilled, err := tokenIndexBackfilled(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if backfilled {
+		return nil, nil
+	}
+
+	// Tokens stored before the index existed can only be found by reading every token
 	credsList, err := s.List(ctx, "token/")
 	if err != nil {
 		return nil, errors.New("failed to get token list: " + err.Error())
@@ -80,7 +134,7 @@ func readTokenUsingClientId(ctx context.Context, s logical.Storage, clientId str
 			return nil, errors.New("unable to retrieve token information: " + err.Error())
 		}
 
-		if token.ClientID == clientId {
+		if token != nil && token.ClientID == clientId {
 			return token, nil
 		}
 	}
@@ -109,6 +163,13 @@ func readToken(ctx context.Context, s logical.Storage, tokenId string) (*astraTo
 }
 
 func saveToken(ctx context.Context, s logical.Storage, token *astraToken, tokenId string) error {
+	if token.ClientID == "" || token.Token == "" {
+		return errors.New("refusing to save incomplete token " + tokenId)
+	}
+	previous, err := readToken(ctx, s, tokenId)
+	if err != nil {
+		return err
+	}
 	entry, err := logical.StorageEntryJSON("token/"+tokenId, token)
 	if err != nil {
 		return err
@@ -117,29 +178,99 @@ func saveToken(ctx context.Context, s logical.Storage, token *astraToken, tokenI
 	if err != nil {
 		return err
 	}
+	// Index the token before dropping the index entry of the token it replaces, if any, so it can
+	//	always be found by client ID
+	err = saveTokenIndex(ctx, s, token.ClientID, tokenId)
+	if err != nil {
+		return err
+	}
+	if previous != nil && previous.ClientID != token.ClientID {
+		return deleteTokenIndex(ctx, s, previous.ClientID, tokenId)
+	}
 	return nil
 }
 
-func (b *datastaxAstraBackend) createToken(ctx context.Context, s logical.Storage, roleEntry *astraRoleEntry, logicalName string, metadata map[string]string) (*astraToken, error) {
+// readInlinePolicy parses the inline policy a token was requested with, if any, and
+// narrows it down to what the role allows
+func readInlinePolicy(d *framework.FieldData, roleEntry *astraRoleEntry) (*dsAstraClient.Policy, []string, error) {
+	policyRaw, ok := d.GetOk("policy")
+	if !ok {
+		return nil, nil, nil
+	}
+	requested, err := parsePolicy(policyRaw.(string), "Temporary role for a token of Vault role "+roleEntry.RoleName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return intersectPolicy(roleEntry, requested)
+}
+
+func (b *datastaxAstraBackend) createToken(ctx context.Context, req *logical.Request, roleEntry *astraRoleEntry, logicalName string, metadata map[string]string, inlinePolicy *dsAstraClient.Policy) (*astraToken, error) {
+	s := req.Storage
 	client, err := b.getClient(ctx, s, roleEntry.OrgId)
 	if err != nil {
 		return nil, err
 	}
 
+	roles := roleEntry.RoleIds
+	temporaryRoleId := ""
+	if inlinePolicy != nil {
+		temporaryRoleId, err = createTemporaryRole(ctx, client, roleEntry, inlinePolicy)
+		if err != nil {
+			b.logger.Error(err.Error())
+			return nil, err
+		}
+		roles = []string{temporaryRoleId}
+	}
+	deleteTemporaryRole := func() {
+		if temporaryRoleId == "" {
+			return
+		}
+		if delErr := client.DeleteCustomRole(context.Background(), temporaryRoleId); delErr != nil {
+			b.logger.Error("Failed to clean up temporary astra role " + temporaryRoleId + ": " + delErr.Error())
+		}
+	}
+	if temporaryRoleId != "" {
+		err = recordMinted(ctx, s, mintedRoleStoragePath, temporaryRoleId, roleEntry.OrgId)
+		if err != nil {
+			deleteTemporaryRole()
+			b.logger.Error(err.Error())
+			return nil, err
+		}
+	}
+
 	var token *astraToken
 
-	token, err = createTokenInAstra(client, roleEntry, logicalName, metadata)
+	token, err = createTokenInAstra(ctx, client, roleEntry, roles, logicalName, metadata)
 	if err != nil {
-		errMsg := "error creating Astra token: " + err.Error()
-		b.logger.Error(errMsg)
-		return nil, errors.New(errMsg)
+		deleteTemporaryRole()
+		err = fmt.Errorf("error creating Astra token: %w", err)
+		b.logger.Error(err.Error())
+		return nil, err
 	}
 
 	if token == nil {
+		deleteTemporaryRole()
 		errMsg := "failed to create Astra token"
 		b.logger.Error(errMsg)
 		return nil, errors.New(errMsg)
 	}
+	err = recordMinted(ctx, s, mintedTokenStoragePath, token.ClientID, roleEntry.OrgId)
+	if err != nil {
+		// Tidy couldn't tell the token was created by Vault, so don't leave it behind in Astra
+		if delErr := deleteTokenFromAstra(context.Background(), client, token.ClientID); delErr != nil {
+			b.logger.Error("Failed to clean up unrecorded token " + token.ClientID + ": " + delErr.Error())
+		}
+		deleteTemporaryRole()
+		b.logger.Error(err.Error())
+		return nil, err
+	}
+	token.TemporaryRoleId = temporaryRoleId
+	token.OwnerEntityID = req.EntityID
+	token.OwnerDisplayName = req.DisplayName
+	if roleEntry.MaxTTL > 0 {
+		token.MaxExpiry = time.Now().Add(roleEntry.MaxTTL).UTC().Format(time.RFC3339)
+	}
 
 	// If logicalName is a non-empty string we will use that along with the org ID and role name to store the token,
 	//	otherwise use the token clientID. In standard mode the logicalName will be set a non-empty string, but in
@@ -153,21 +284,32 @@ func (b *datastaxAstraBackend) createToken(ctx context.Context, s logical.Storag
 
 	err = saveToken(ctx, s, token, tokenId)
 	if err != nil {
+		// Vault would lose track of the token, so don't leave it behind in Astra. This must happen
+		//	even if the request was cancelled in the meantime.
+		if delErr := deleteTokenFromAstra(context.Background(), client, token.ClientID); delErr != nil {
+			b.logger.Error("Failed to clean up unsaved token " + token.ClientID + ": " + delErr.Error())
+		}
+		deleteTemporaryRole()
 		return nil, err
 	}
 
 	return token, nil
 }
 
-func (b *datastaxAstraBackend) generateTokenResponse(token *astraToken, tokenId string, roleEntry *astraRoleEntry) (*logical.Response, error) {
-	resp := b.Secret(astraTokenType).Response(
-		token.ToResponseData(),
-		map[string]interface{}{
-			"orgId":    roleEntry.OrgId,
-			"clientId": token.ClientID,
-			"roleName": roleEntry.RoleName,
-			"tokenId":  tokenId,
-		})
+func (b *datastaxAstraBackend) generateTokenResponse(token *astraToken, tokenId string, roleEntry *astraRoleEntry, warnings []string) (*logical.Response, error) {
+	internalData := map[string]interface{}{
+		"orgId":    roleEntry.OrgId,
+		"clientId": token.ClientID,
+		"roleName": roleEntry.RoleName,
+		"tokenId":  tokenId,
+	}
+	if token.TemporaryRoleId != "" {
+		internalData["temporaryRoleId"] = token.TemporaryRoleId
+	}
+	resp := b.Secret(astraTokenType).Response(token.ToResponseData(), internalData)
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
 
 	if roleEntry.TTL > 0 {
 		resp.Secret.TTL = roleEntry.TTL
@@ -196,6 +338,15 @@ func (b *datastaxAstraBackend) pathCredentialsStandardMode(ctx context.Context,
 				return nil, err
 			}
 		}
+		// The org ID and role name may come from the path, so they must match the token for ACL policies to hold
+		roleName := d.Get("role_name").(string)
+		if token.OrgID != orgId || (roleName != "" && token.RoleName != roleName) {
+			return nil, errors.New("no token found with clientId " + clientId)
+		}
+		err = b.checkTokenAccess(ctx, req, token)
+		if err != nil {
+			return nil, err
+		}
 		return &logical.Response{Data: token.ToResponseData()}, nil
 	}
 
@@ -221,7 +372,11 @@ func (b *datastaxAstraBackend) pathCredentialsStandardMode(ctx context.Context,
 	if roleEntry == nil {
 		return nil, errors.New("unable to find role " + roleName)
 	}
-	if roleEntry.RoleId == "" {
+	err = b.checkRoleBindings(req, roleEntry)
+	if err != nil {
+		return nil, err
+	}
+	if len(roleEntry.RoleIds) == 0 {
 		return nil, nil
 	}
 
@@ -236,6 +391,10 @@ func (b *datastaxAstraBackend) pathCredentialsStandardMode(ctx context.Context,
 		if token == nil {
 			return nil, errors.New("unable to find token for org ID" + orgId + ", role " + roleName + ", with logical name " + logicalName)
 		}
+		err = b.checkTokenReadAccess(req, roleEntry, token)
+		if err != nil {
+			return nil, err
+		}
 		return &logical.Response{Data: token.ToResponseData()}, nil
 	}
 
@@ -253,11 +412,16 @@ func (b *datastaxAstraBackend) pathCredentialsStandardMode(ctx context.Context,
 		metadata = metadataRaw.(map[string]string)
 	}
 
-	token, err = b.createToken(ctx, req.Storage, roleEntry, logicalName, metadata)
+	inlinePolicy, warnings, err := readInlinePolicy(d, roleEntry)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	token, err = b.createToken(ctx, req, roleEntry, logicalName, metadata, inlinePolicy)
 	if err != nil {
 		return nil, err
 	}
-	return b.generateTokenResponse(token, tokenId, roleEntry)
+	return b.generateTokenResponse(token, tokenId, roleEntry, warnings)
 }
 
 func (b *datastaxAstraBackend) pathCredentialsSidecarMode(ctx context.Context, req *logical.Request, d *framework.FieldData, orgId string) (*logical.Response, error) {
@@ -274,7 +438,11 @@ func (b *datastaxAstraBackend) pathCredentialsSidecarMode(ctx context.Context, r
 	if roleEntry == nil {
 		return nil, errors.New("unable to find role " + roleName)
 	}
-	if roleEntry.RoleId == "" {
+	err = b.checkRoleBindings(req, roleEntry)
+	if err != nil {
+		return nil, err
+	}
+	if len(roleEntry.RoleIds) == 0 {
 		return nil, nil
 	}
 
@@ -287,14 +455,19 @@ func (b *datastaxAstraBackend) pathCredentialsSidecarMode(ctx context.Context, r
 		metadata = metadataRaw.(map[string]string)
 	}
 
+	inlinePolicy, warnings, err := readInlinePolicy(d, roleEntry)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	// In sidecar mode logical_name is ignored, so make it an empty string
 	// to force storing the token using its ClientId
-	token, err := b.createToken(ctx, req.Storage, roleEntry, "", metadata)
+	token, err := b.createToken(ctx, req, roleEntry, "", metadata, inlinePolicy)
 	if err != nil {
 		return nil, err
 	}
 
-	return b.generateTokenResponse(token, token.ClientID, roleEntry)
+	return b.generateTokenResponse(token, token.ClientID, roleEntry, warnings)
 }
 
 func (b *datastaxAstraBackend) pathCredentialsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -311,13 +484,14 @@ func (b *datastaxAstraBackend) pathCredentialsRead(ctx context.Context, req *log
 		return nil, errors.New("unable to find config for org ID" + orgId)
 	}
 
+	var resp *logical.Response
 	switch config.CallerMode {
 	case StandardCallerMode:
-		return b.pathCredentialsStandardMode(ctx, req, d, orgId, true)
+		resp, err = b.pathCredentialsStandardMode(ctx, req, d, orgId, true)
 	case SidecarCallerMode:
-		return b.pathCredentialsSidecarMode(ctx, req, d, orgId)
+		resp, err = b.pathCredentialsSidecarMode(ctx, req, d, orgId)
 	}
-	return nil, nil
+	return resp, toVaultError(err)
 }
 
 func (b *datastaxAstraBackend) pathCredentialsUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -334,12 +508,98 @@ func (b *datastaxAstraBackend) pathCredentialsUpdate(ctx context.Context, req *l
 		return nil, errors.New("unable to find config for org ID" + orgId)
 	}
 
+	var resp *logical.Response
 	switch config.CallerMode {
 	case StandardCallerMode:
-		return b.pathCredentialsStandardMode(ctx, req, d, orgId, false)
+		resp, err = b.pathCredentialsStandardMode(ctx, req, d, orgId, false)
 	case SidecarCallerMode:
-		return b.pathCredentialsSidecarMode(ctx, req, d, orgId)
+		resp, err = b.pathCredentialsSidecarMode(ctx, req, d, orgId)
+	}
+	return resp, toVaultError(err)
+}
+
+// checkTokenAccess makes sure the request may act on an existing token, by reading it back or revoking it,
+// going by the bindings and read_access of the token's role
+func (b *datastaxAstraBackend) checkTokenAccess(ctx context.Context, req *logical.Request, token *astraToken) error {
+	roleEntry, err := readRole(ctx, req.Storage, token.RoleName, token.OrgID)
+	if err != nil {
+		return errors.New("error retrieving role " + token.RoleName + ": " + err.Error())
+	}
+	if roleEntry == nil {
+		// Without its role there is no read_access to go by, so only its owner may access the token
+		roleEntry = &astraRoleEntry{RoleName: token.RoleName, OrgId: token.OrgID, ReadAccess: tokenReadAccessOwner}
+	}
+	err = b.checkRoleBindings(req, roleEntry)
+	if err != nil {
+		return err
+	}
+	return b.checkTokenReadAccess(req, roleEntry, token)
+}
+
+// pathCredentialsDelete revokes a token right away, without needing its lease ID, e.g. when it has leaked
+func (b *datastaxAstraBackend) pathCredentialsDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	orgIdRaw, ok := d.GetOk("org_id")
+	if !ok {
+		return logical.ErrorResponse("please provide an org_id argument"), nil
 	}
+	orgId := orgIdRaw.(string)
+	roleName := d.Get("role_name").(string)
+
+	var token *astraToken
+	var err error
+	clientIdRaw, ok := d.GetOk("client_id")
+	if ok {
+		clientId := clientIdRaw.(string)
+		token, err = readTokenUsingClientId(ctx, req.Storage, clientId)
+		if err != nil {
+			return nil, err
+		}
+		if token == nil {
+			// The client ID may be the previous version of a rotated token still in its grace period
+			retired, err := readRetiredToken(ctx, req.Storage, clientId)
+			if err != nil {
+				return nil, err
+			}
+			if retired != nil && retired.OrgID == orgId && (roleName == "" || retired.RoleName == roleName) {
+				err = b.checkTokenAccess(ctx, req, &astraToken{ClientID: retired.ClientID, OrgID: retired.OrgID, RoleName: retired.RoleName, OwnerEntityID: retired.OwnerEntityID})
+				if err != nil {
+					return nil, err
+				}
+				err = b.deleteRetiredToken(ctx, req.Storage, retired)
+				if err != nil {
+					return nil, toVaultError(err)
+				}
+				return nil, nil
+			}
+		}
+		// The org ID and role name may come from the path, so they must match the token for ACL policies to hold
+		if token == nil || token.OrgID != orgId || (roleName != "" && token.RoleName != roleName) {
+			return logical.ErrorResponse("no token found with clientId " + clientId + " in org ID " + orgId), nil
+		}
+	} else {
+		logicalName := d.Get("logical_name").(string)
+		if roleName == "" || logicalName == "" {
+			return logical.ErrorResponse("please provide a client_id argument, or role_name and logical_name arguments"), nil
+		}
+		token, err = readToken(ctx, req.Storage, calculateTokenId(&astraRoleEntry{OrgId: orgId, RoleName: roleName}, logicalName))
+		if err != nil {
+			return nil, err
+		}
+		if token == nil {
+			return logical.ErrorResponse("unable to find token for org ID " + orgId + ", role " + roleName + ", with logical name " + logicalName), nil
+		}
+	}
+	err = b.checkTokenAccess(ctx, req, token)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.revokeToken(ctx, req.Storage, token)
+	if err != nil {
+		return nil, toVaultError(err)
+	}
+
+	b.logger.Info(fmt.Sprintf("Revoked token '%s' of role %s in org ID %s on request of %s", token.ClientID, token.RoleName, orgId, req.DisplayName))
 	return nil, nil
 }
 
@@ -349,4 +609,5 @@ Generate a AstraCS token from a specific Vault role.
 
 const pathCredentialsHelpDesc = `
 This path generates a Astra CS token based on a particular role. 
+Deleting a token through this path revokes it in Astra right away.
 `
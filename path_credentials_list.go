@@ -9,6 +9,7 @@ import (
 func pathCredentialsList(b *datastaxAstraBackend) *framework.Path {
 	return &framework.Path{
 		Pattern: "org/tokens/?$",
+		Fields:  PathList(CredentialsPathList).listFields(),
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ListOperation: &framework.PathOperation{
 				Callback: b.pathCredsList,
@@ -19,5 +20,5 @@ func pathCredentialsList(b *datastaxAstraBackend) *framework.Path {
 }
 
 func (b *datastaxAstraBackend) pathCredsList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	return b.pathObjectList(ctx, req, CredentialsPathList)
+	return b.pathObjectList(ctx, req, d, CredentialsPathList)
 }
@@ -0,0 +1,309 @@
+package datastax_astra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	retiredTokenStoragePath    = "retired-token/"
+	defaultRotationGracePeriod = time.Hour
+)
+
+// retiredToken records the previous version of a rotated token, which is deleted from Astra once its grace period is over
+type retiredToken struct {
+	ClientID    string    `json:"clientId"`
+	OrgID       string    `json:"orgId"`
+	RoleName    string    `json:"roleName"`
+	LogicalName string    `json:"logicalName"`
+	RetiredOn   time.Time `json:"retiredOn"`
+	DeleteAfter time.Time `json:"deleteAfter"`
+	// OwnerEntityID is kept so the previous version can only be revoked by those who may read the token
+	OwnerEntityID string `json:"ownerEntityId"`
+}
+
+// pathCredentialsRotate extends the Vault API with `/org/token/rotate` and `/creds/<org_id>/<role_name>/rotate`
+// endpoints that replace a standard mode token with a new version under the same logical name.
+func pathCredentialsRotate(b *datastaxAstraBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern:         "org/token/rotate",
+			Fields:          credentialsRotateFields(),
+			Operations:      credentialsRotateOperations(b),
+			HelpSynopsis:    pathCredentialsRotateHelpSyn,
+			HelpDescription: pathCredentialsRotateHelpDesc,
+		},
+		{
+			Pattern:         "creds/" + framework.GenericNameRegex("org_id") + "/" + framework.GenericNameRegex("role_name") + "/rotate",
+			Fields:          credentialsRotateFields(),
+			Operations:      credentialsRotateOperations(b),
+			HelpSynopsis:    pathCredentialsRotateHelpSyn,
+			HelpDescription: pathCredentialsRotateHelpDesc,
+		},
+	}
+}
+
+func credentialsRotateFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"org_id": {
+			Type:        framework.TypeString,
+			Description: "name of the org for which token is being rotated",
+			Required:    true,
+		},
+		"role_name": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "name of the role for which token is being rotated",
+		},
+		"logical_name": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "Logical name of the token to rotate",
+		},
+		"rotation_grace_period": {
+			Type:        framework.TypeDurationSecond,
+			Description: "How long the previous version of the token keeps working before it is deleted from Astra. Defaults to 1 hour; set to 0 to delete it right away.",
+			Default:     int(defaultRotationGracePeriod.Seconds()),
+		},
+	}
+}
+
+func credentialsRotateOperations(b *datastaxAstraBackend) map[logical.Operation]framework.OperationHandler {
+	return map[logical.Operation]framework.OperationHandler{
+		logical.UpdateOperation: &framework.PathOperation{
+			Callback: b.pathCredentialsRotate,
+			Summary:  "Replace a token with a new version, keeping the previous one valid for a grace period.",
+		},
+	}
+}
+
+func readRetiredToken(ctx context.Context, s logical.Storage, clientId string) (*retiredToken, error) {
+	entry, err := s.Get(ctx, retiredTokenStoragePath+clientId)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	result := &retiredToken{}
+	err = entry.DecodeJSON(result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func saveRetiredToken(ctx context.Context, s logical.Storage, token *retiredToken) error {
+	entry, err := logical.StorageEntryJSON(retiredTokenStoragePath+token.ClientID, token)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func (b *datastaxAstraBackend) pathCredentialsRotate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	orgIdRaw, ok := d.GetOk("org_id")
+	if !ok {
+		return logical.ErrorResponse("please provide an org_id argument"), nil
+	}
+	orgId := orgIdRaw.(string)
+	roleName := d.Get("role_name").(string)
+	logicalName := d.Get("logical_name").(string)
+	if roleName == "" || logicalName == "" {
+		return logical.ErrorResponse("please provide role_name and logical_name arguments"), nil
+	}
+	gracePeriod := time.Duration(d.Get("rotation_grace_period").(int)) * time.Second
+	if gracePeriod < 0 {
+		return logical.ErrorResponse("rotation_grace_period must not be negative"), nil
+	}
+
+	config, err := readConfig(ctx, req.Storage, orgId)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("unable to find config for org ID " + orgId)
+	}
+	// Sidecar mode tokens have no logical name to find the new version by
+	if config.CallerMode != StandardCallerMode {
+		return logical.ErrorResponse("tokens can only be rotated in standard caller mode"), nil
+	}
+
+	roleEntry, err := readRole(ctx, req.Storage, roleName, orgId)
+	if err != nil {
+		return nil, errors.New("error retrieving role " + roleName + ": " + err.Error())
+	}
+	if roleEntry == nil {
+		return nil, errors.New("unable to find role " + roleName)
+	}
+	err = b.checkRoleBindings(req, roleEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenId := calculateTokenId(roleEntry, logicalName)
+	previous, err := readToken(ctx, req.Storage, tokenId)
+	if err != nil {
+		return nil, errors.New("error attempting to retrieve token for org ID " + orgId + ", role " + roleName + ", with logical name " + logicalName + ": " + err.Error())
+	}
+	if previous == nil {
+		return logical.ErrorResponse("unable to find token for org ID " + orgId + ", role " + roleName + ", with logical name " + logicalName), nil
+	}
+	// Rotating hands out the token's new version, so it takes the same access as reading it back
+	err = b.checkTokenReadAccess(req, roleEntry, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := b.rotateToken(ctx, req.Storage, roleEntry, tokenId, previous, gracePeriod)
+	if err != nil {
+		return nil, toVaultError(err)
+	}
+
+	resp, err := b.generateTokenResponse(token, tokenId, roleEntry, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Data["previousClientId"] = previous.ClientID
+	resp.Data["previousDeleteAfter"] = formatTime(time.Now().Add(gracePeriod))
+	return resp, nil
+}
+
+// rotateToken mints the new version of a token and stores it in place of the previous one. The previous
+// version is recorded as retired until the grace period is over, or deleted from Astra right away if there is none.
+func (b *datastaxAstraBackend) rotateToken(ctx context.Context, s logical.Storage, roleEntry *astraRoleEntry, tokenId string, previous *astraToken, gracePeriod time.Duration) (*astraToken, error) {
+	client, err := b.getClient(ctx, s, roleEntry.OrgId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	// A token requested with an inline policy keeps its temporary Astra role, which the new version takes over
+	roles := roleEntry.RoleIds
+	if previous.TemporaryRoleId != "" {
+		roles = []string{previous.TemporaryRoleId}
+	}
+	token, err := createTokenInAstra(ctx, client, roleEntry, roles, previous.LogicalName, previous.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Astra token: %w", err)
+	}
+	err = recordMinted(ctx, s, mintedTokenStoragePath, token.ClientID, roleEntry.OrgId)
+	if err != nil {
+		if delErr := deleteTokenFromAstra(context.Background(), client, token.ClientID); delErr != nil {
+			b.logger.Error("Failed to clean up unrecorded token " + token.ClientID + ": " + delErr.Error())
+		}
+		return nil, err
+	}
+	token.TemporaryRoleId = previous.TemporaryRoleId
+	token.OwnerEntityID = previous.OwnerEntityID
+	token.OwnerDisplayName = previous.OwnerDisplayName
+	if roleEntry.MaxTTL > 0 {
+		token.MaxExpiry = time.Now().Add(roleEntry.MaxTTL).UTC().Format(time.RFC3339)
+	}
+
+	now := time.Now()
+	retired := &retiredToken{
+		ClientID:      previous.ClientID,
+		OrgID:         previous.OrgID,
+		RoleName:      previous.RoleName,
+		LogicalName:   previous.LogicalName,
+		RetiredOn:     now,
+		DeleteAfter:   now.Add(gracePeriod),
+		OwnerEntityID: previous.OwnerEntityID,
+	}
+	if gracePeriod > 0 {
+		err = saveRetiredToken(ctx, s, retired)
+	}
+	if err == nil {
+		err = saveToken(ctx, s, token, tokenId)
+	}
+	if err != nil {
+		// Vault would lose track of the new version, so don't leave it behind in Astra
+		if delErr := deleteTokenFromAstra(context.Background(), client, token.ClientID); delErr != nil {
+			b.logger.Error("Failed to clean up unsaved token " + token.ClientID + ": " + delErr.Error())
+		}
+		if delErr := s.Delete(context.Background(), retiredTokenStoragePath+previous.ClientID); delErr != nil {
+			b.logger.Error("Failed to clean up retired token record " + previous.ClientID + ": " + delErr.Error())
+		}
+		return nil, err
+	}
+
+	if gracePeriod > 0 {
+		b.logger.Info(fmt.Sprintf("Rotated token '%s' to '%s'; the previous version is deleted after %s", previous.ClientID, token.ClientID, formatTime(retired.DeleteAfter)))
+		return token, nil
+	}
+	err = deleteTokenFromAstra(ctx, client, previous.ClientID)
+	if err != nil {
+		// The new version is already in use, so record the previous one for the next cleanup to retry
+		b.logger.Error("Failed to delete previous version of rotated token " + previous.ClientID + "; retrying later: " + err.Error())
+		if saveErr := saveRetiredToken(ctx, s, retired); saveErr != nil {
+			b.logger.Error("Failed to record retired token " + previous.ClientID + ": " + saveErr.Error())
+		}
+	} else if err = forgetMinted(ctx, s, mintedTokenStoragePath, previous.ClientID); err != nil {
+		// Tidy deletes the record once it sees the token is gone from Astra
+		b.logger.Warn(err.Error())
+	}
+	b.logger.Info(fmt.Sprintf("Rotated token '%s' to '%s'", previous.ClientID, token.ClientID))
+	return token, nil
+}
+
+// deleteRetiredTokens deletes the previous versions of rotated tokens whose grace period is over from Astra
+func (b *datastaxAstraBackend) deleteRetiredTokens(ctx context.Context, s logical.Storage, now time.Time) error {
+	clientIds, err := s.List(ctx, retiredTokenStoragePath)
+	if err != nil {
+		return errors.New("error loading retired token list: " + err.Error())
+	}
+
+	for _, clientId := range clientIds {
+		retired, err := readRetiredToken(ctx, s, clientId)
+		if err != nil {
+			b.logger.Error("Unable to read retired token " + clientId + ": " + err.Error())
+			continue
+		}
+		if retired == nil || now.Before(retired.DeleteAfter) {
+			continue
+		}
+		err = b.deleteRetiredToken(ctx, s, retired)
+		if err != nil {
+			b.logger.Error("Unable to delete retired token " + clientId + "; retrying later: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// deleteRetiredToken deletes the previous version of a rotated token from Astra and then its record
+func (b *datastaxAstraBackend) deleteRetiredToken(ctx context.Context, s logical.Storage, retired *retiredToken) error {
+	client, err := b.getClient(ctx, s, retired.OrgID)
+	if err != nil {
+		return fmt.Errorf("error getting client: %w", err)
+	}
+	err = deleteTokenFromAstra(ctx, client, retired.ClientID)
+	if err != nil {
+		return err
+	}
+	err = forgetMinted(ctx, s, mintedTokenStoragePath, retired.ClientID)
+	if err != nil {
+		return err
+	}
+	err = s.Delete(ctx, retiredTokenStoragePath+retired.ClientID)
+	if err != nil {
+		return err
+	}
+
+	b.logger.Info("Deleted retired token '" + retired.ClientID + "' of role " + retired.RoleName + " in org ID " + retired.OrgID)
+	return nil
+}
+
+const pathCredentialsRotateHelpSyn = `
+Rotate a token in place, keeping the previous version valid for a grace period.
+`
+
+const pathCredentialsRotateHelpDesc = `
+This path mints a new Astra token for an existing standard mode token, found by
+'org_id', 'role_name' and 'logical_name', and stores it as the token's current
+version. The previous version keeps working for 'rotation_grace_period' so apps
+can pick up the new one without an outage, and is then deleted from Astra.
+`
@@ -0,0 +1,105 @@
+package datastax_astra
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenRotation makes sure rotating a token stores a new version under the same
+// logical name and keeps the previous one in Astra until its grace period is over.
+func TestTokenRotation(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/" + org_id + "/app",
+		Storage:   s,
+		Data:      map[string]interface{}{"role_id": server.AddRole("Read Only User").ID, "read_access": "any"},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "creds/" + org_id + "/app",
+		Storage:   s,
+		Data:      map[string]interface{}{"logical_name": "web"},
+	})
+	require.NoError(t, err)
+	first := resp
+
+	rotate := func(gracePeriod string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "org/token/rotate",
+			Storage:   s,
+			Data: map[string]interface{}{
+				"org_id":                org_id,
+				"role_name":             "app",
+				"logical_name":          "web",
+				"rotation_grace_period": gracePeriod,
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "%v", resp)
+		return resp
+	}
+
+	second := rotate("1h")
+	require.Equal(t, first.Data["clientId"], second.Data["previousClientId"])
+	require.NotEqual(t, first.Data["clientId"], second.Data["clientId"])
+	require.NotNil(t, second.Secret)
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/" + org_id + "/app",
+		Storage:   s,
+		Data:      map[string]interface{}{"logical_name": "web"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, second.Data["token"], resp.Data["token"])
+
+	// The previous version works until its grace period is over
+	_, ok := server.Token(first.Data["clientId"].(string))
+	require.True(t, ok)
+	require.NoError(t, b.deleteRetiredTokens(ctx, s, time.Now()))
+	_, ok = server.Token(first.Data["clientId"].(string))
+	require.True(t, ok)
+	require.NoError(t, b.deleteRetiredTokens(ctx, s, time.Now().Add(2*time.Hour)))
+	_, ok = server.Token(first.Data["clientId"].(string))
+	require.False(t, ok)
+	retired, err := s.List(ctx, retiredTokenStoragePath)
+	require.NoError(t, err)
+	require.Empty(t, retired)
+
+	// Without a grace period the previous version is deleted right away
+	third := rotate("0")
+	_, ok = server.Token(second.Data["clientId"].(string))
+	require.False(t, ok)
+	_, ok = server.Token(third.Data["clientId"].(string))
+	require.True(t, ok)
+
+	// Revoking the lease of a previous version leaves the current one alone
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Path:      "creds/" + org_id + "/app",
+		Storage:   s,
+		Secret:    first.Secret,
+	})
+	require.NoError(t, err)
+	token, err := readTokenUsingClientId(ctx, s, third.Data["clientId"].(string))
+	require.NoError(t, err)
+	require.NotNil(t, token)
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "creds/" + org_id + "/app/rotate",
+		Storage:   s,
+		Data:      map[string]interface{}{"logical_name": "unknown"},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+}
@@ -2,32 +2,20 @@ package datastax_astra
 
 import (
 	"context"
-	"encoding/json"
-	"log"
 	"net/http"
 	"testing"
 	"time"
 
+	"github.com/datastax/vault-plugin-secrets-datastax-astra/astratest"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/helper/logging"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
 )
 
-const (
-	mockLocalServerPort = "8080"
-)
-
-type HttpResponse struct {
-	ClientID    string   `json:"clientId"`
-	Secret      string   `json:"secret"`
-	Token       string   `json:"token"`
-	OrgID       string   `json:"orgId"`
-	Roles       []string `json:"roles"`
-	GeneratedOn string   `json:"generatedOn"`
-}
-
 // newAcceptanceTestEnv creates a test environment for credentials
-func newAcceptanceTestEnv() (*testEnv, error) {
+// backed by the given fake Astra API
+func newAcceptanceTestEnv(server *astratest.Server) (*testEnv, error) {
 	ctx := context.Background()
 
 	maxLease, _ := time.ParseDuration("60s")
@@ -44,71 +32,29 @@ func newAcceptanceTestEnv() (*testEnv, error) {
 		return nil, err
 	}
 	return &testEnv{
-		AstraToken:  envVarAstraToken,
-		URL:         envVarAstraURL,
+		AstraToken:  server.AddToken("adminRoleId").Token,
+		URL:         server.URL,
 		OrgId:       envVarAstraOrgId,
 		LogicalName: envVarAstraLogicalName,
 		RoleName:    envVarRoleName,
 		TTL:         envVarTTL,
 		MaxTTL:      envVarMaxTTL,
-		RoleId:      envVarRoleId,
+		RoleId:      server.AddRole("Test Role").ID,
 		CallerMode:  envVarCallerMode,
+		EntityId:    "testEntityId",
 		Backend:     b,
 		Context:     ctx,
 		Storage:     &logical.InmemStorage{},
 	}, nil
 }
 
-func clientIdSecretsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	switch r.Method {
-	case "POST":
-		tokenResponse := HttpResponse{
-			ClientID:    "test_client_id",
-			Secret:      "test_secret",
-			Token:       "test_token",
-			OrgID:       envVarAstraOrgId,
-			Roles:       []string{envVarRoleId},
-			GeneratedOn: time.Now().Format(time.RFC3339),
-		}
-
-		tokenResponseJson, err := json.Marshal(tokenResponse)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-		w.WriteHeader(http.StatusOK)
-		_, err = w.Write(tokenResponseJson)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-	case "DELETE":
-		w.WriteHeader(http.StatusNoContent)
-	default:
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(`{"message": "not found"}`))
-	}
-}
-
 // TestAcceptanceUserToken tests a series of steps to make
 // sure the token creation work correctly.
 func TestAcceptanceUserToken(t *testing.T) {
-	http.HandleFunc("/v2/clientIdSecrets", clientIdSecretsHandler)
-	http.HandleFunc("/v2/clientIdSecrets/", clientIdSecretsHandler)
+	server := astratest.NewServer(envVarAstraOrgId)
+	defer server.Close()
 
-	mockServer := &http.Server{
-		Addr: ":" + mockLocalServerPort,
-	}
-
-	// Start a local server to mock the Astra API
-	go func() {
-		log.Println("Listening on localhost:" + mockLocalServerPort)
-		err := mockServer.ListenAndServe()
-		if err != nil && err != http.ErrServerClosed {
-			log.Fatalf("ListenAndServe(): %s", err)
-		}
-	}()
-
-	acceptanceTestEnv, err := newAcceptanceTestEnv()
+	acceptanceTestEnv, err := newAcceptanceTestEnv(server)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -121,14 +67,359 @@ func TestAcceptanceUserToken(t *testing.T) {
 		t.Run("read user token cred", acceptanceTestEnv.ReadUserTokenUsingClientId)
 	}
 	t.Run("renew user token cred", acceptanceTestEnv.RenewToken)
+	// Revoking retries through a briefly failing Astra API and deletes the token there
+	server.InjectFailure(astratest.Failure{Method: http.MethodDelete, StatusCode: http.StatusServiceUnavailable, RetryAfter: "0", Count: 2})
 	t.Run("revoke user token cred", acceptanceTestEnv.RevokeToken)
+	require.Len(t, server.ClientIDs(), 1)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// TestInlinePolicy makes sure a token requested with an inline policy gets a temporary
+// Astra role limited to what its role allows, and that revoking the token deletes it.
+func TestInlinePolicy(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+	baseRole := server.AddRole("Read Only User")
 
-	// Shutdown the server after testing is done
-	err = mockServer.Shutdown(ctx)
+	dbA := "drn:astra:org:" + org_id + ":db:dbA"
+	for roleName, data := range map[string]map[string]interface{}{
+		"jobs":  {"allowed_actions": "db-cql,db-table-*", "allowed_resources": dbA + ":*"},
+		"fixed": {},
+	} {
+		data["org_id"] = org_id
+		data["role_name"] = roleName
+		data["role_id"] = baseRole.ID
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role",
+			Storage:   s,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	}
+
+	requestToken := func(roleName string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "org/token",
+			Storage:   s,
+			Data: map[string]interface{}{
+				"org_id":       org_id,
+				"role_name":    roleName,
+				"logical_name": "job1",
+				"policy":       `{"actions": ["db-cql", "db-table-select", "org-write"], "resources": ["` + dbA + `:keyspace:ks1", "drn:astra:org:` + org_id + `:db:dbB"]}`,
+			},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := requestToken("fixed")
+	require.EqualError(t, resp.Error(), "role fixed does not allow inline policies; set its allowed_actions and allowed_resources")
+	require.Len(t, server.Roles(), 1)
+
+	resp = requestToken("jobs")
+	require.False(t, resp.IsError())
+	require.Len(t, resp.Warnings, 2)
+	roles := resp.Data["roles"].([]string)
+	require.Len(t, roles, 1)
+	temporaryRole, ok := server.Role(roles[0])
+	require.True(t, ok)
+	require.Equal(t, []string{"db-cql", "db-table-select"}, temporaryRole.Policy.Actions)
+	require.Equal(t, []string{dbA + ":keyspace:ks1"}, temporaryRole.Policy.Resources)
+	token, _ := server.Token(resp.Data["clientId"].(string))
+	require.Equal(t, []string{temporaryRole.ID}, token.Roles)
+	minted, err := readMinted(ctx, s, mintedRoleStoragePath, org_id)
+	require.NoError(t, err)
+	require.Contains(t, minted, temporaryRole.ID)
+
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Path:      "org/token",
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	require.NoError(t, err)
+	_, ok = server.Role(temporaryRole.ID)
+	require.False(t, ok)
+	_, ok = server.Token(token.ClientID)
+	require.False(t, ok)
+	// Their records are deleted along with them, rather than left for tidy
+	minted, err = readMinted(ctx, s, mintedRoleStoragePath, org_id)
+	require.NoError(t, err)
+	require.Empty(t, minted)
+	minted, err = readMinted(ctx, s, mintedTokenStoragePath, org_id)
+	require.NoError(t, err)
+	require.Empty(t, minted)
+}
+
+// identityTestSystemView serves the entities and groups of a test's Vault identity store
+type identityTestSystemView struct {
+	logical.StaticSystemView
+	entities map[string]*logical.Entity
+	groups   map[string][]*logical.Group
+}
+
+func (d identityTestSystemView) EntityInfo(entityID string) (*logical.Entity, error) {
+	return d.entities[entityID], nil
+}
+
+func (d identityTestSystemView) GroupsForEntity(entityID string) ([]*logical.Group, error) {
+	return d.groups[entityID], nil
+}
+
+// getIdentityTestBackend constructs a test backend whose identity store holds the given entities,
+// and the groups of each of them.
+func getIdentityTestBackend(tb testing.TB, entities map[string]*logical.Entity, groups map[string][]*logical.Group) (*datastaxAstraBackend, logical.Storage) {
+	tb.Helper()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = new(logical.InmemStorage)
+	config.Logger = hclog.NewNullLogger()
+	config.System = identityTestSystemView{
+		StaticSystemView: *logical.TestSystemView(),
+		entities:         entities,
+		groups:           groups,
+	}
+
+	b, err := Factory(context.Background(), config)
 	if err != nil {
-		log.Fatalf("Server shutdown failed: %s", err)
+		tb.Fatal(err)
 	}
+
+	return b.(*datastaxAstraBackend), config.StorageView
+}
+
+// TestRoleBindings makes sure tokens of a role with bound entities, groups,
+// entity metadata or CIDRs are only issued to requests that match them.
+func TestRoleBindings(t *testing.T) {
+	ctx := context.Background()
+	b, s := getIdentityTestBackend(t,
+		map[string]*logical.Entity{
+			"entityA": {ID: "entityA", Metadata: map[string]string{"team": "reporting"}},
+			"entityB": {ID: "entityB", Metadata: map[string]string{"team": "billing"}},
+		},
+		map[string][]*logical.Group{
+			"entityA": {{ID: "groupReporting"}},
+		})
+	server := configureTestBackend(t, b, s, nil)
+	astraRole := server.AddRole("Read Only User")
+
+	writeRole := func(roleName string, data map[string]interface{}) *logical.Response {
+		data["role_id"] = astraRole.ID
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + org_id + "/" + roleName,
+			Storage:   s,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+	require.Nil(t, writeRole("by-entity", map[string]interface{}{"bound_entity_ids": "entityA"}))
+	require.Nil(t, writeRole("by-group", map[string]interface{}{"bound_group_ids": "groupReporting"}))
+	require.Nil(t, writeRole("by-metadata", map[string]interface{}{"bound_entity_metadata": "team=billing"}))
+	require.Nil(t, writeRole("by-cidr", map[string]interface{}{"bound_cidrs": "10.0.0.0/8"}))
+	require.True(t, writeRole("bad-cidr", map[string]interface{}{"bound_cidrs": "10.0.0.1"}).IsError())
+
+	requestToken := func(roleName, entityId, remoteAddr string) error {
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "creds/" + org_id + "/" + roleName,
+			Storage:    s,
+			EntityID:   entityId,
+			Connection: &logical.Connection{RemoteAddr: remoteAddr},
+			Data:       map[string]interface{}{"logical_name": roleName + "-" + entityId},
+		})
+		return err
+	}
+	require.NoError(t, requestToken("by-entity", "entityA", "192.168.0.1"))
+	require.Error(t, requestToken("by-entity", "entityB", "192.168.0.1"))
+	require.Error(t, requestToken("by-entity", "", "192.168.0.1"))
+	require.NoError(t, requestToken("by-group", "entityA", "192.168.0.1"))
+	require.Error(t, requestToken("by-group", "entityB", "192.168.0.1"))
+	require.NoError(t, requestToken("by-metadata", "entityB", "192.168.0.1"))
+	require.Error(t, requestToken("by-metadata", "entityA", "192.168.0.1"))
+	require.NoError(t, requestToken("by-cidr", "", "10.1.2.3"))
+	err := requestToken("by-cidr", "", "192.168.0.1")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "permission denied")
+	require.Len(t, server.ClientIDs(), 5)
+}
+
+// TestTokenReadAccess makes sure an existing token is only read back
+// by the entities its role's read_access allows.
+func TestTokenReadAccess(t *testing.T) {
+	ctx := context.Background()
+	// The owner and teammate share a group the outsider doesn't belong to
+	b, s := getIdentityTestBackend(t,
+		map[string]*logical.Entity{
+			"owner":    {ID: "owner"},
+			"teammate": {ID: "teammate"},
+			"outsider": {ID: "outsider"},
+		},
+		map[string][]*logical.Group{
+			"owner":    {{ID: "groupReporting"}},
+			"teammate": {{ID: "groupReporting"}, {ID: "groupBilling"}},
+		})
+	server := configureTestBackend(t, b, s, nil)
+	astraRole := server.AddRole("Read Only User")
+
+	for roleName, readAccess := range map[string]string{"owned": "", "shared": "group", "open": "any"} {
+		data := map[string]interface{}{"role_id": astraRole.ID}
+		if readAccess != "" {
+			data["read_access"] = readAccess
+		}
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "roles/" + org_id + "/" + roleName,
+			Storage:   s,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "creds/" + org_id + "/" + roleName,
+			Storage:   s,
+			EntityID:  "owner",
+			Data:      map[string]interface{}{"logical_name": "job"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "owner", resp.Data["ownerEntityId"])
+	}
+
+	readBack := func(roleName, entityId string) error {
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/" + org_id + "/" + roleName,
+			Storage:   s,
+			EntityID:  entityId,
+			Data:      map[string]interface{}{"logical_name": "job"},
+		})
+		return err
+	}
+	require.NoError(t, readBack("owned", "owner"))
+	require.Error(t, readBack("owned", "teammate"))
+	require.Error(t, readBack("owned", ""))
+	require.NoError(t, readBack("shared", "owner"))
+	require.NoError(t, readBack("shared", "teammate"))
+	require.Error(t, readBack("shared", "outsider"))
+	require.NoError(t, readBack("open", "outsider"))
+
+	// Reading by client ID is held to the same rules
+	token, err := readToken(ctx, s, calculateTokenId(&astraRoleEntry{OrgId: org_id, RoleName: "owned"}, "job"))
+	require.NoError(t, err)
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "org/token",
+		Storage:   s,
+		EntityID:  "outsider",
+		Data:      map[string]interface{}{"org_id": org_id, "client_id": token.ClientID},
+	})
+	require.ErrorContains(t, err, "permission denied")
+
+	// Roles written before read_access existed keep letting anyone read their tokens
+	role, err := readRole(ctx, s, "owned", org_id)
+	require.NoError(t, err)
+	require.Equal(t, "owner", role.ReadAccess)
+	entry := &logical.StorageEntry{
+		Key:   roleStoragePath + roleKey(org_id, "owned"),
+		Value: []byte(`{"role_name":"owned","role_ids":["` + astraRole.ID + `"],"org_id":"` + org_id + `","ttl":3600000000000,"max_ttl":3600000000000}`),
+	}
+	require.NoError(t, s.Put(ctx, entry))
+	require.NoError(t, readBack("owned", "outsider"))
+}
+
+// TestManualRevoke makes sure a token can be revoked without its lease, and
+// that its lease then no longer affects a token reissued with the same name.
+func TestManualRevoke(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+	astraRole := server.AddRole("Read Only User")
+	for _, roleName := range []string{"reporting", "billing"} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "roles/" + org_id + "/" + roleName,
+			Storage:   s,
+			Data:      map[string]interface{}{"role_id": astraRole.ID},
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	}
+
+	issue := func() *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "creds/" + org_id + "/reporting",
+			Storage:   s,
+			EntityID:  "owner",
+			Data:      map[string]interface{}{"logical_name": "app"},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+	revokeAs := func(entityId, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      path,
+			Storage:   s,
+			EntityID:  entityId,
+			Data:      data,
+		})
+	}
+	revoke := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := revokeAs("owner", path, data)
+		require.NoError(t, err)
+		return resp
+	}
+
+	leaked := issue()
+	// Revoking takes the same access as reading the token back, so another team can't revoke it
+	_, err := revokeAs("outsider", "creds/"+org_id+"/reporting", map[string]interface{}{"logical_name": "app"})
+	require.ErrorContains(t, err, "permission denied")
+	_, err = revokeAs("outsider", "org/token", map[string]interface{}{"org_id": org_id, "client_id": leaked.Data["clientId"]})
+	require.ErrorContains(t, err, "permission denied")
+	_, ok := server.Token(leaked.Data["clientId"].(string))
+	require.True(t, ok)
+
+	require.Nil(t, revoke("creds/"+org_id+"/reporting", map[string]interface{}{"logical_name": "app"}))
+	_, ok = server.Token(leaked.Data["clientId"].(string))
+	require.False(t, ok)
+	minted, err := readMinted(ctx, s, mintedTokenStoragePath, org_id)
+	require.NoError(t, err)
+	require.Empty(t, minted)
+
+	// Renewing the lease of the revoked token ends it instead
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RenewOperation,
+		Path:      "creds/" + org_id + "/reporting",
+		Storage:   s,
+		Secret:    leaked.Secret,
+	})
+	require.NoError(t, err)
+	require.Equal(t, revokedLeaseTTL, resp.Secret.TTL)
+	require.Contains(t, resp.Warnings[0], "has been revoked")
+
+	// Revoking the old lease leaves a token reissued with the same logical name alone
+	reissued := issue()
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Path:      "creds/" + org_id + "/reporting",
+		Storage:   s,
+		Secret:    leaked.Secret,
+	})
+	require.NoError(t, err)
+	token, err := readTokenUsingClientId(ctx, s, reissued.Data["clientId"].(string))
+	require.NoError(t, err)
+	require.NotNil(t, token)
+
+	// A path for another role can't be used to revoke the token by its client ID
+	clientId := reissued.Data["clientId"].(string)
+	require.True(t, revoke("creds/"+org_id+"/billing", map[string]interface{}{"client_id": clientId}).IsError())
+	require.Nil(t, revoke("org/token", map[string]interface{}{"org_id": org_id, "client_id": clientId}))
+	_, ok = server.Token(clientId)
+	require.False(t, ok)
+	require.True(t, revoke("org/token", map[string]interface{}{"org_id": org_id, "role_name": "reporting", "logical_name": "app"}).IsError())
 }
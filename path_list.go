@@ -3,8 +3,14 @@ package datastax_astra
 import (
     "context"
     "errors"
-    "github.com/hashicorp/vault/sdk/logical"
+    "fmt"
+    "sort"
     "strings"
+    "time"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/helper/strutil"
+    "github.com/hashicorp/vault/sdk/logical"
 )
 
 type PathList int
@@ -16,6 +22,16 @@ const (
     CredentialsPathList
 )
 
+const (
+    listSortByKey         = "key"
+    listSortByOrgId       = "org_id"
+    listSortByRoleName    = "role_name"
+    listSortByGeneratedOn = "generated_on"
+    listSortByMaxExpiry   = "max_expiry"
+    listSortOrderAsc      = "asc"
+    listSortOrderDesc     = "desc"
+)
+
 func (pl PathList) String() string {
     switch pl {
     case ConfigPathList:
@@ -29,46 +45,425 @@ func (pl PathList) String() string {
     }
 }
 
-// GetObjectInformation Retrieves the object entry's ID and data. Specifically, we return the object ID, a callback
-//  function to retrieve the response data, and an error to determine if we should call the callback function.
-func (pl PathList) GetObjectInformation(ctx context.Context, req *logical.Request, key string) (string, ToResponseDataFunc, error) {
+// listEntry is an object as it is listed: its ID, the attributes it can be filtered and sorted by, and a callback
+//  function to retrieve its response data
+type listEntry struct {
+    Key          string
+    OrgID        string
+    RoleName     string
+    Metadata     map[string]string
+    GeneratedOn  string
+    MaxExpiry    string
+    ResponseData ToResponseDataFunc
+}
+
+// sortValue returns the attribute of the entry a list is sorted by
+func (e *listEntry) sortValue(sortBy string) string {
+    switch sortBy {
+    case listSortByOrgId:
+        return e.OrgID
+    case listSortByRoleName:
+        return e.RoleName
+    case listSortByGeneratedOn:
+        return e.GeneratedOn
+    case listSortByMaxExpiry:
+        return e.MaxExpiry
+    default:
+        return e.Key
+    }
+}
+
+// listFilter selects the objects a list returns. Unset criteria match every object.
+type listFilter struct {
+    OrgID         string
+    RoleName      string
+    Metadata      map[string]string
+    ExpiresBefore time.Time
+}
+
+func (f *listFilter) matches(e *listEntry) bool {
+    if f.OrgID != "" && e.OrgID != f.OrgID {
+        return false
+    }
+    if f.RoleName != "" && e.RoleName != f.RoleName {
+        return false
+    }
+    for key, value := range f.Metadata {
+        if e.Metadata[key] != value {
+            return false
+        }
+    }
+    if !f.ExpiresBefore.IsZero() {
+        // Tokens without a max_ttl never expire
+        maxExpiry, err := time.Parse(time.RFC3339, e.MaxExpiry)
+        if err != nil || !maxExpiry.Before(f.ExpiresBefore) {
+            return false
+        }
+    }
+    return true
+}
+
+// sortOptions returns the values sort_by accepts for a type of object
+func (pl PathList) sortOptions() []string {
+    switch pl {
+    case RolePathList:
+        return []string{listSortByKey, listSortByOrgId}
+    case CredentialsPathList:
+        return []string{listSortByKey, listSortByOrgId, listSortByRoleName, listSortByGeneratedOn, listSortByMaxExpiry}
+    default:
+        return []string{listSortByKey}
+    }
+}
+
+// listFields returns the pagination, filtering and sorting arguments a type of object can be listed with
+func (pl PathList) listFields() map[string]*framework.FieldSchema {
+    fields := map[string]*framework.FieldSchema{
+        "after": {
+            Type:        framework.TypeString,
+            Description: "Only list the " + pl.String() + "s after the one with this key, in the requested order. Pass the 'next' value of the previous page to get the next one.",
+        },
+        "limit": {
+            Type:        framework.TypeInt,
+            Description: "Maximum number of " + pl.String() + "s to list. 0, the default, lists all of them.",
+        },
+        "org_id": {
+            Type:        framework.TypeString,
+            Description: "Only list the " + pl.String() + "s of this organization.",
+        },
+        "sort_by": {
+            Type:        framework.TypeString,
+            Description: "Attribute to sort by: one of " + strings.Join(pl.sortOptions(), ", ") + ". Defaults to key.",
+            Default:     listSortByKey,
+        },
+        "sort_order": {
+            Type:        framework.TypeString,
+            Description: "Sort order: 'asc' (the default) or 'desc'.",
+            Default:     listSortOrderAsc,
+        },
+    }
+    if pl == RolePathList || pl == CredentialsPathList {
+        fields["role_name"] = &framework.FieldSchema{
+            Type:        framework.TypeLowerCaseString,
+            Description: "Only list the " + pl.String() + "s of the role with this name.",
+        }
+    }
+    if pl == CredentialsPathList {
+        fields["metadata"] = &framework.FieldSchema{
+            Type:        framework.TypeKVPairs,
+            Description: "Only list the tokens with all of these metadata key=value pairs.",
+        }
+        fields["expires_before"] = &framework.FieldSchema{
+            Type:        framework.TypeString,
+            Description: "Only list the tokens whose lease can't be renewed past this time, given as an RFC 3339 timestamp or as a duration from now such as '24h'.",
+        }
+    }
+    return fields
+}
+
+// GetObjectInformation Retrieves the object entry's ID, the attributes it can be filtered and sorted by, and a
+//  callback function to retrieve the response data. A nil entry means the object no longer exists.
+//  The data of configs and tokens leaves out their secrets, which must be read explicitly.
+func (pl PathList) GetObjectInformation(ctx context.Context, req *logical.Request, key string) (*listEntry, error) {
     switch pl {
     case ConfigPathList:
         obj, err := readConfig(ctx, req.Storage, key)
-        return key, obj.ToResponseData, err
+        if err != nil || obj == nil {
+            return nil, err
+        }
+        return &listEntry{Key: key, OrgID: obj.OrgId, ResponseData: obj.ToListData}, nil
     case RolePathList:
         obj, err := readRoleUsingKey(ctx, req.Storage, key)
-        // The role storage key is contains the Org ID and Role Name delimited by ":"
+        if err != nil || obj == nil {
+            return nil, err
+        }
+        // The role storage key contains the Org ID and Role Name delimited by ":"
         //  For consistency and to avoid confusion, we return just the Role Name
-        return strings.Split(key, roleStorageKeyDelimiter)[1], obj.ToResponseData, err
+        return &listEntry{Key: obj.RoleName, OrgID: obj.OrgId, RoleName: obj.RoleName, ResponseData: obj.ToResponseData}, nil
     case CredentialsPathList:
         obj, err := readToken(ctx, req.Storage, key)
-        // Regardless of the storage key used for the token, always return the Client ID
-        return obj.ClientID, obj.ToResponseData, err
+        if err != nil || obj == nil {
+            return nil, err
+        }
+        return tokenListEntry(obj), nil
+    default:
+        return nil, errors.New("response requested for unknown object type")
+    }
+}
+
+// tokenListEntry returns the list entry of a token. Regardless of the storage key used for the token, always
+//  return the Client ID
+func tokenListEntry(obj *astraToken) *listEntry {
+    return &listEntry{
+        Key:          obj.ClientID,
+        OrgID:        obj.OrgID,
+        RoleName:     obj.RoleName,
+        Metadata:     obj.Metadata,
+        GeneratedOn:  obj.GeneratedOn,
+        MaxExpiry:    obj.MaxExpiry,
+        ResponseData: obj.ToListData,
+    }
+}
+
+// listKeys returns the keys objects are stored or indexed under, without reading the objects. Tokens are listed by
+//  Client ID, which is only known without reading them once every token has been indexed; until then ok is false.
+func (pl PathList) listKeys(ctx context.Context, s logical.Storage) ([]string, bool, error) {
+    prefix := pl.String() + "/"
+    if pl == CredentialsPathList {
+        backfilled, err := tokenIndexBackfilled(ctx, s)
+        if err != nil || !backfilled {
+            return nil, false, err
+        }
+        prefix = tokenIndexStoragePath
+    }
+    keys, err := s.List(ctx, prefix)
+    if err != nil {
+        return nil, false, errors.New("error loading " + pl.String() + " list: " + err.Error())
+    }
+    return keys, true, nil
+}
+
+// listKey returns the key an object is listed by from a key returned by listKeys: the Role Name for roles, whose
+//  storage key also contains the Org ID, and the key itself otherwise
+func (pl PathList) listKey(key string) string {
+    if pl == RolePathList {
+        if parts := strings.SplitN(key, roleStorageKeyDelimiter, 2); len(parts) == 2 {
+            return parts[1]
+        }
+    }
+    return key
+}
+
+// errListDuplicateKey is returned when objects of several orgs would be listed under the same key
+type errListDuplicateKey struct {
+    key string
+}
+
+func (e *errListDuplicateKey) Error() string {
+    return "role name " + e.key + " is used in several organizations; pass an org_id argument to list the roles of one of them"
+}
+
+// getListEntry reads the object with a key returned by listKeys
+func (pl PathList) getListEntry(ctx context.Context, req *logical.Request, key string) (*listEntry, error) {
+    if pl != CredentialsPathList {
+        return pl.GetObjectInformation(ctx, req, key)
+    }
+    obj, err := readTokenUsingClientId(ctx, req.Storage, key)
+    if err != nil || obj == nil {
+        return nil, err
+    }
+    return tokenListEntry(obj), nil
+}
+
+// skipKey reports whether the storage key of a config or role shows it belongs to another org than the filter's,
+//  so that it needn't be read
+func (pl PathList) skipKey(filter *listFilter, key string) bool {
+    if filter.OrgID == "" {
+        return false
+    }
+    switch pl {
+    case ConfigPathList:
+        return key != filter.OrgID
+    case RolePathList:
+        return !strings.HasPrefix(key, filter.OrgID+roleStorageKeyDelimiter)
     default:
-        return "", nil, errors.New("response requested for unknown object type")
+        return false
     }
 }
 
-func (b *datastaxAstraBackend) pathObjectList(ctx context.Context, req *logical.Request, pl PathList) (*logical.Response, error) {
+func (b *datastaxAstraBackend) pathObjectList(ctx context.Context, req *logical.Request, d *framework.FieldData, pl PathList) (*logical.Response, error) {
     pathList := pl.String()
-    objList, err := req.Storage.List(ctx, pathList+"/")
+    filter := &listFilter{
+        OrgID: d.Get("org_id").(string),
+    }
+    if roleName, ok := d.GetOk("role_name"); ok {
+        filter.RoleName = roleName.(string)
+    }
+    if metadata, ok := d.GetOk("metadata"); ok {
+        filter.Metadata = metadata.(map[string]string)
+    }
+    if expiresBefore, ok := d.GetOk("expires_before"); ok && expiresBefore.(string) != "" {
+        t, err := parseListTime(expiresBefore.(string))
+        if err != nil {
+            return logical.ErrorResponse("invalid expires_before argument: " + err.Error()), nil
+        }
+        filter.ExpiresBefore = t
+    }
+    sortBy := d.Get("sort_by").(string)
+    if !strutil.StrListContains(pl.sortOptions(), sortBy) {
+        return logical.ErrorResponse("unrecognised sort_by argument; valid values are " + strings.Join(pl.sortOptions(), ", ")), nil
+    }
+    sortOrder := d.Get("sort_order").(string)
+    if sortOrder != listSortOrderAsc && sortOrder != listSortOrderDesc {
+        return logical.ErrorResponse("unrecognised sort_order argument; valid values are 'asc' or 'desc'"), nil
+    }
+    limit := d.Get("limit").(int)
+    if limit < 0 {
+        return logical.ErrorResponse("limit must not be negative"), nil
+    }
+
+    after := d.Get("after").(string)
+
+    var entries []*listEntry
+    next := ""
+    keys, ok, err := pl.listKeys(ctx, req.Storage)
     if err != nil {
-        return nil, errors.New("error loading " + pathList + " list: " + err.Error())
+        return nil, err
     }
-    if len(objList) == 0 {
-        return nil, errors.New("no " + pathList + "s found")
+    if ok && sortBy == listSortByKey {
+        // Sorting by key only needs the keys, so only the objects up to the end of the page are read
+        storageKeys := map[string]string{}
+        listKeys := []string{}
+        for _, key := range keys {
+            if pl.skipKey(filter, key) {
+                continue
+            }
+            listKey := pl.listKey(key)
+            if _, ok := storageKeys[listKey]; ok {
+                return logical.ErrorResponse((&errListDuplicateKey{key: listKey}).Error()), nil
+            }
+            storageKeys[listKey] = key
+            listKeys = append(listKeys, listKey)
+        }
+        keys = listKeys
+        sort.Strings(keys)
+        if sortOrder == listSortOrderDesc {
+            for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+                keys[i], keys[j] = keys[j], keys[i]
+            }
+        }
+        if after != "" {
+            // The object may have been deleted since the previous page, which keys alone are enough to carry on from
+            keys = keys[sort.Search(len(keys), func(i int) bool {
+                if sortOrder == listSortOrderDesc {
+                    return keys[i] < after
+                }
+                return keys[i] > after
+            }):]
+        }
+        for _, key := range keys {
+            entry, err := pl.getListEntry(ctx, req, storageKeys[key])
+            if err != nil {
+                return nil, errors.New("failed to retrieve " + pathList + " information: " + err.Error())
+            }
+            if entry == nil || !filter.matches(entry) {
+                continue
+            }
+            if limit > 0 && len(entries) == limit {
+                next = entries[limit-1].Key
+                break
+            }
+            entries = append(entries, entry)
+        }
+    } else {
+        entries, next, err = b.listSortedEntries(ctx, req, pl, filter, sortBy, sortOrder, after, limit)
+        if errors.Is(err, errListAfterNotFound) {
+            return logical.ErrorResponse(fmt.Sprintf("unable to find %s %s to list the %ss after", pathList, after, pathList)), nil
+        }
+        var duplicateErr *errListDuplicateKey
+        if errors.As(err, &duplicateErr) {
+            return logical.ErrorResponse(duplicateErr.Error()), nil
+        }
+        if err != nil {
+            return nil, err
+        }
     }
+
     keyInfo := map[string]interface{}{}
-    var keys []string
+    pageKeys := []string{}
+    for _, entry := range entries {
+        pageKeys = append(pageKeys, entry.Key)
+        keyInfo[entry.Key] = entry.ResponseData()
+    }
+    resp := logical.ListResponseWithInfo(pageKeys, keyInfo)
+    // Nothing to list is not an error, so always return the keys, even if there are none
+    resp.Data["keys"] = pageKeys
+    if next != "" {
+        resp.Data["next"] = next
+    }
+    return resp, nil
+}
+
+// errListAfterNotFound is returned when the object to list the objects after can't be found
+var errListAfterNotFound = errors.New("after key not found")
+
+// listSortedEntries lists the objects sorted by an attribute other than their key, which means reading all of them
+func (b *datastaxAstraBackend) listSortedEntries(ctx context.Context, req *logical.Request, pl PathList, filter *listFilter, sortBy, sortOrder, after string, limit int) ([]*listEntry, string, error) {
+    pathList := pl.String()
+    objList, err := req.Storage.List(ctx, pathList+"/")
+    if err != nil {
+        return nil, "", errors.New("error loading " + pathList + " list: " + err.Error())
+    }
+    entries := []*listEntry{}
+    listed := map[string]bool{}
     for _, key := range objList {
+        if pl.skipKey(filter, key) {
+            continue
+        }
         // Get the key/value (ID/Data) of the object
-        objId, objResponseData, err := pl.GetObjectInformation(ctx, req, key)
+        entry, err := pl.GetObjectInformation(ctx, req, key)
         if err != nil {
-            return nil, errors.New("failed to retrieve" + pathList + "information: " + err.Error())
+            return nil, "", errors.New("failed to retrieve " + pathList + " information: " + err.Error())
+        }
+        if entry == nil || !filter.matches(entry) {
+            continue
+        }
+        if _, ok := listed[entry.Key]; ok {
+            return nil, "", &errListDuplicateKey{key: entry.Key}
         }
-        keys = append(keys, objId)
-        keyInfo[objId] = objResponseData()
+        listed[entry.Key] = true
+        entries = append(entries, entry)
     }
-    return logical.ListResponseWithInfo(keys, keyInfo), nil
-}
\ No newline at end of file
+
+    // Sort on the key as well so that pages are stable when several objects share the sorted value
+    sort.SliceStable(entries, func(i, j int) bool {
+        vi, vj := entries[i].sortValue(sortBy), entries[j].sortValue(sortBy)
+        if vi == vj {
+            vi, vj = entries[i].Key, entries[j].Key
+        }
+        if sortOrder == listSortOrderDesc {
+            return vi > vj
+        }
+        return vi < vj
+    })
+
+    if after != "" {
+        start := -1
+        for i, entry := range entries {
+            if entry.Key == after {
+                start = i + 1
+                break
+            }
+        }
+        if start < 0 && sortBy != listSortByKey {
+            return nil, "", errListAfterNotFound
+        }
+        if start < 0 {
+            start = sort.Search(len(entries), func(i int) bool {
+                if sortOrder == listSortOrderDesc {
+                    return entries[i].Key < after
+                }
+                return entries[i].Key > after
+            })
+        }
+        entries = entries[start:]
+    }
+    next := ""
+    if limit > 0 && len(entries) > limit {
+        entries = entries[:limit]
+        next = entries[limit-1].Key
+    }
+    return entries, next, nil
+}
+
+// parseListTime parses a time given as an RFC 3339 timestamp or as a duration from now
+func parseListTime(value string) (time.Time, error) {
+    t, err := time.Parse(time.RFC3339, value)
+    if err == nil {
+        return t, nil
+    }
+    duration, durationErr := time.ParseDuration(value)
+    if durationErr != nil {
+        return time.Time{}, errors.New("'" + value + "' is neither an RFC 3339 timestamp nor a duration")
+    }
+    return time.Now().Add(duration), nil
+}
@@ -0,0 +1,148 @@
+package datastax_astra
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestListPaging makes sure the list endpoints filter, sort and paginate, and return an empty list when
+// nothing matches.
+func TestListPaging(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+
+	list := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "%v", resp)
+		return resp
+	}
+
+	// Nothing stored yet is an empty list rather than an error
+	for _, path := range []string{"configs/", "roles/", "org/tokens/"} {
+		require.Equal(t, []string{}, list(path, nil).Data["keys"], path)
+	}
+
+	const otherOrgId = "otherOrgId"
+	for _, orgId := range []string{org_id, otherOrgId} {
+		require.NoError(t, saveConfig(ctx, &astraConfig{OrgId: orgId, AstraToken: "AstraCS:root:secret", URL: "https://api.astra.datastax.com"}, s))
+		require.NoError(t, saveRole(ctx, s, &astraRoleEntry{RoleName: "app", OrgId: orgId, RoleIds: []string{"roleId"}}))
+	}
+	require.NoError(t, saveRole(ctx, s, &astraRoleEntry{RoleName: "reports", OrgId: org_id, RoleIds: []string{"roleId"}}))
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		token := &astraToken{
+			ClientID:    fmt.Sprintf("client%d", i),
+			OrgID:       org_id,
+			RoleName:    "app",
+			Token:       fmt.Sprintf("AstraCS:client%d:secret", i),
+			LogicalName: fmt.Sprintf("token%d", i),
+			GeneratedOn: now.Add(time.Duration(-i) * time.Hour).UTC().Format(time.RFC3339),
+			MaxExpiry:   now.Add(time.Duration(i+1) * time.Hour).UTC().Format(time.RFC3339),
+			Metadata:    map[string]string{"team": []string{"reporting", "billing"}[i%2]},
+		}
+		require.NoError(t, saveToken(ctx, s, token, token.ClientID))
+	}
+	other := &astraToken{ClientID: "otherClient", OrgID: otherOrgId, RoleName: "app", Token: "AstraCS:otherClient:secret"}
+	require.NoError(t, saveToken(ctx, s, other, other.ClientID))
+
+	require.Equal(t, []string{org_id}, list("configs/", map[string]interface{}{"org_id": org_id}).Data["keys"])
+	require.Equal(t, []string{"app", "reports"}, list("roles/", map[string]interface{}{"org_id": org_id}).Data["keys"])
+	require.Equal(t, []string{"app"}, list("roles/", map[string]interface{}{"org_id": otherOrgId}).Data["keys"])
+	require.Equal(t, otherOrgId, list("roles/", map[string]interface{}{"org_id": otherOrgId}).Data["key_info"].(map[string]interface{})["app"].(map[string]interface{})["org_id"])
+	require.Equal(t, []string{}, list("roles/", map[string]interface{}{"org_id": "unknownOrgId"}).Data["keys"])
+
+	// Tokens can be filtered by org, role, metadata and expiry
+	require.Equal(t, []string{"otherClient"}, list("org/tokens/", map[string]interface{}{"org_id": otherOrgId}).Data["keys"])
+	require.Equal(t, []string{"client1", "client3"}, list("org/tokens/", map[string]interface{}{"metadata": "team=billing"}).Data["keys"])
+	require.Equal(t, []string{"client0", "client1"}, list("org/tokens/", map[string]interface{}{"expires_before": "150m"}).Data["keys"])
+	require.Equal(t, []string{}, list("org/tokens/", map[string]interface{}{"role_name": "reports"}).Data["keys"])
+	require.Equal(t, []string{"client4", "client3", "client2", "client1", "client0"},
+		list("org/tokens/", map[string]interface{}{"org_id": org_id, "sort_by": "generated_on"}).Data["keys"])
+
+	// Pages follow on from one another until there is no next page
+	page := func(path string, data map[string]interface{}) []string {
+		var keys []string
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 10)
+			resp := list(path, data)
+			keys = append(keys, resp.Data["keys"].([]string)...)
+			next, ok := resp.Data["next"]
+			if !ok {
+				return keys
+			}
+			data["after"] = next
+		}
+	}
+	require.Equal(t, []string{"app", "reports"}, page("roles/", map[string]interface{}{"org_id": org_id, "limit": 1}))
+	require.Equal(t, []string{"reports", "app"}, page("roles/", map[string]interface{}{"org_id": org_id, "limit": 1, "sort_order": "desc"}))
+	// Roles are listed by name, so roles with the same name in different orgs need an org_id to tell them apart
+	for _, sortBy := range []string{"key", "org_id"} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "roles/",
+			Storage:   s,
+			Data:      map[string]interface{}{"sort_by": sortBy},
+		})
+		require.NoError(t, err)
+		require.EqualError(t, resp.Error(), "role name app is used in several organizations; pass an org_id argument to list the roles of one of them")
+	}
+	// Tokens are paged on their index once every token has been indexed, and by reading all of them until then
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			require.NoError(t, b.backfillTokenIndex(ctx, s))
+		}
+		require.Equal(t, []string{"client4", "client3", "client2", "client1", "client0"},
+			page("org/tokens/", map[string]interface{}{"org_id": org_id, "limit": 2, "sort_order": "desc"}))
+		require.Equal(t, []string{"client0", "client2", "client4"},
+			page("org/tokens/", map[string]interface{}{"metadata": "team=reporting", "limit": 2}))
+		require.Equal(t, []string{"client4", "client3", "client2", "client1", "client0"},
+			page("org/tokens/", map[string]interface{}{"org_id": org_id, "limit": 2, "sort_by": "generated_on"}))
+	}
+
+	// A page sorted by key only reads the tokens up to the end of the page
+	counting := &countingStorage{Storage: s, prefix: "token/"}
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "org/tokens/",
+		Storage:   counting,
+		Data:      map[string]interface{}{"limit": 2},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"client0", "client1"}, resp.Data["keys"])
+	require.Equal(t, 3, counting.gets)
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "org/tokens/",
+		Storage:   s,
+		Data:      map[string]interface{}{"sort_by": "logical_name"},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+}
+
+// countingStorage counts the reads of the storage entries under a prefix
+type countingStorage struct {
+	logical.Storage
+	prefix string
+	gets   int
+}
+
+func (c *countingStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	if strings.HasPrefix(key, c.prefix) {
+		c.gets++
+	}
+	return c.Storage.Get(ctx, key)
+}
@@ -4,9 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
+
+	dsAstraClient "github.com/datastax/astra-client-go/v2/astra"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/cidrutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
-	"time"
 )
 
 const (
@@ -16,53 +21,118 @@ const (
 	defaultMaxTtl			= time.Duration(24*3600) * time.Second
 )
 
-func pathRole(b *datastaxAstraBackend) *framework.Path {
-	return &framework.Path{
-		Pattern: "role",
-		Fields: map[string]*framework.FieldSchema{
-			"role_name": {
-				Type:        framework.TypeLowerCaseString,
-				Description: "The name of the role as it should appear in Vault.",
-			},
-			"role_id": {
-				Type:        framework.TypeString,
-				Description: "UUID of the role as seen in Astra.",
-			},
-			"org_id": {
-				Type:        framework.TypeString,
-				Description: "UUID of the organization in Astra.",
-			},
-			"ttl": {
-				Type:        framework.TypeDurationSecond,
-				Description: "Default ttl in seconds, minutes or hours for the token. If unset or set to 0, it will default to 24 hours. If this value is bigger than max_ttl, it will be clamped to the max_ttl value. Use the duration initials after the number. for e.g. 5s, 5m, 5h",
-				Required:    false,
-			},
-			"max_ttl": {
-				Type:        framework.TypeDurationSecond,
-				Description: "Maximum ttl in seconds, minutes or hours for the token. If unset or set to 0, it will default to 24 hours. Use the duration initials after the number. for e.g. 5s, 5m, 5h",
-				Required:    false,
-			},
-		},
-		Operations: map[logical.Operation]framework.OperationHandler{
-			logical.CreateOperation: &framework.PathOperation{
-				Callback: b.pathRoleWrite,
-			},
-			logical.UpdateOperation: &framework.PathOperation{
-				Callback: b.pathRoleWrite,
-			},
-			logical.ReadOperation: &framework.PathOperation{
-				Callback: b.pathRoleRead,
-			},
-			logical.DeleteOperation: &framework.PathOperation{
-				Callback: b.pathRoleDelete,
-			},
-		},
-		ExistenceCheck:  b.pathRoleExistenceCheck,
-		HelpSynopsis:    pathRoleHelpSynopsis,
-		HelpDescription: pathRoleHelpDescription,
+// pathRole extends the Vault API with the `/role` endpoint, which takes the org ID and role name as arguments,
+//  and `/roles/<org_id>/<role_name>`, which takes them from the path so ACL policies can be scoped to them.
+func pathRole(b *datastaxAstraBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern:         "role",
+			Fields:          roleFields(),
+			Operations:      roleOperations(b),
+			ExistenceCheck:  b.pathRoleExistenceCheck,
+			HelpSynopsis:    pathRoleHelpSynopsis,
+			HelpDescription: pathRoleHelpDescription,
+		},
+		{
+			Pattern:         "roles/" + framework.GenericNameRegex("org_id") + "/" + framework.GenericNameRegex("role_name"),
+			Fields:          roleFields(),
+			Operations:      roleOperations(b),
+			ExistenceCheck:  b.pathRoleExistenceCheck,
+			HelpSynopsis:    pathRoleHelpSynopsis,
+			HelpDescription: pathRoleHelpDescription,
+		},
 	}
 }
 
+func roleFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"role_name": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "The name of the role as it should appear in Vault.",
+		},
+		"role_ids": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "UUIDs of the roles as seen in Astra. Tokens created for the role get all of these Astra roles. One of role_ids, role_id or astra_role_name must be provided.",
+		},
+		"role_id": {
+			Type:        framework.TypeString,
+			Description: "UUID of a single role as seen in Astra.",
+		},
+		"astra_role_name": {
+			Type:        framework.TypeString,
+			Description: "Name of the role as seen in Astra, e.g. 'Database Administrator'. It is resolved to the role's UUID when the role is written.",
+		},
+		"allowed_actions": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Astra policy actions, e.g. 'db-cql', that an inline policy requested along with a token may grant. '*' may be used as a prefix or suffix glob.",
+		},
+		"allowed_resources": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Astra resources, e.g. 'drn:astra:org:<org ID>:db:<db ID>:keyspace:*', that an inline policy requested along with a token may apply to. '*' may be used as a prefix or suffix glob.",
+		},
+		"policy": {
+			Type:        framework.TypeString,
+			Description: "Astra policy document as JSON, with the 'actions' granted on the 'resources' it lists. The plugin creates a custom role in Astra with this policy, keeps it up to date when the role is written, and deletes it when the role is deleted.",
+		},
+		"bound_entity_ids": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "IDs of the Vault entities allowed to get tokens of this role. If set, requests from any other entity are denied.",
+		},
+		"bound_group_ids": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "IDs of the Vault identity groups allowed to get tokens of this role. If set, the requesting entity must be a member of at least one of them.",
+		},
+		"bound_entity_metadata": {
+			Type:        framework.TypeKVPairs,
+			Description: "Metadata, as key=value pairs, the requesting Vault entity must have to get tokens of this role.",
+		},
+		"bound_cidrs": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "CIDR blocks, e.g. '10.0.0.0/8', that requests for tokens of this role must come from.",
+		},
+		"read_access": {
+			Type:        framework.TypeString,
+			Description: "Who may read an existing token of this role back in standard caller mode: 'owner' (the default), only the Vault entity that created it; 'group', also entities that share an identity group with it; or 'any'.",
+		},
+		"org_id": {
+			Type:        framework.TypeString,
+			Description: "UUID of the organization in Astra.",
+		},
+		"ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Default ttl in seconds, minutes or hours for the token. If unset or set to 0, it will default to 24 hours. If this value is bigger than max_ttl, it will be clamped to the max_ttl value. Use the duration initials after the number. for e.g. 5s, 5m, 5h",
+			Required:    false,
+		},
+		"max_ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Maximum ttl in seconds, minutes or hours for the token. If unset or set to 0, it will default to 24 hours. Use the duration initials after the number. for e.g. 5s, 5m, 5h",
+			Required:    false,
+		},
+	}
+}
+
+func roleOperations(b *datastaxAstraBackend) map[logical.Operation]framework.OperationHandler {
+	return map[logical.Operation]framework.OperationHandler{
+		logical.CreateOperation: &framework.PathOperation{
+			Callback: b.pathRoleWrite,
+		},
+		logical.UpdateOperation: &framework.PathOperation{
+			Callback: b.pathRoleWrite,
+		},
+		logical.ReadOperation: &framework.PathOperation{
+			Callback: b.pathRoleRead,
+		},
+		logical.DeleteOperation: &framework.PathOperation{
+			Callback: b.pathRoleDelete,
+		},
+	}
+}
+
+// roleKey returns the key, relative to roleStoragePath, that a role is stored under
+func roleKey(orgId, roleName string) string {
+	return orgId + roleStorageKeyDelimiter + roleName
+}
+
 func readRoleUsingKey(ctx context.Context, s logical.Storage, roleKey string) (*astraRoleEntry, error) {
 	if roleKey == "" {
 		return nil, errors.New("role key is an empty string")
@@ -93,11 +163,11 @@ func readRole(ctx context.Context, s logical.Storage, roleName, orgId string) (*
 		return nil, errors.New("org ID is an empty string")
 	}
 
-	return readRoleUsingKey(ctx, s, orgId+roleStorageKeyDelimiter+roleName)
+	return readRoleUsingKey(ctx, s, roleKey(orgId, roleName))
 }
 
 func saveRole(ctx context.Context, s logical.Storage, role *astraRoleEntry) error {
-	entry, err := logical.StorageEntryJSON(roleStoragePath+role.OrgId+roleStorageKeyDelimiter+role.RoleName, role)
+	entry, err := logical.StorageEntryJSON(roleStoragePath+roleKey(role.OrgId, role.RoleName), role)
 	if err != nil {
 		return err
 	}
@@ -154,11 +224,114 @@ func (b *datastaxAstraBackend) pathRoleWrite(ctx context.Context,
 		role.OrgId = orgId
 	}
 
-	roleId, ok := d.GetOk("role_id")
+	roleIds, roleIdsOk := d.GetOk("role_ids")
+	roleId, roleIdOk := d.GetOk("role_id")
+	astraRoleName, astraRoleNameOk := d.GetOk("astra_role_name")
+	policyRaw, policyOk := d.GetOk("policy")
+	if roleIdsOk && (roleIdOk || astraRoleNameOk) {
+		return logical.ErrorResponse("role_ids can't be combined with role_id or astra_role_name"), nil
+	}
+	if policyOk && (roleIdsOk || roleIdOk || astraRoleNameOk) {
+		return logical.ErrorResponse("policy can't be combined with role_ids, role_id or astra_role_name"), nil
+	}
+	if role.Policy != nil && (roleIdsOk || roleIdOk || astraRoleNameOk) {
+		return logical.ErrorResponse("role " + roleName + " manages its own astra role; update its policy instead"), nil
+	}
+	var policy *dsAstraClient.Policy
+	if policyOk {
+		policy, err = parsePolicy(policyRaw.(string), "Managed by Vault role "+roleName)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	} else if roleIdsOk || roleIdOk || astraRoleNameOk {
+		// Each Astra role is looked up by its ID, its name, or both
+		type astraRoleLookup struct{ id, name string }
+		var lookups []astraRoleLookup
+		if roleIdsOk {
+			seen := map[string]bool{}
+			for _, id := range roleIds.([]string) {
+				if !seen[id] {
+					seen[id] = true
+					lookups = append(lookups, astraRoleLookup{id: id})
+				}
+			}
+			if len(lookups) == 0 {
+				return logical.ErrorResponse("role_ids must not be empty"), nil
+			}
+		} else {
+			if !roleIdOk {
+				roleId = ""
+			}
+			if !astraRoleNameOk {
+				astraRoleName = ""
+			}
+			lookups = []astraRoleLookup{{id: roleId.(string), name: astraRoleName.(string)}}
+		}
+
+		client, err := b.getClient(ctx, req.Storage, orgId)
+		if err != nil {
+			return nil, fmt.Errorf("error getting client: %w", err)
+		}
+		astraRoles, err := client.ListRoles(ctx)
+		if err != nil {
+			return nil, toVaultError(fmt.Errorf("error listing roles in astra: %w", err))
+		}
+		role.RoleIds = nil
+		role.AstraRoleNames = nil
+		for _, lookup := range lookups {
+			astraRole, err := findAstraRole(astraRoles, lookup.id, lookup.name)
+			if err != nil {
+				return logical.ErrorResponse(err.Error() + " in org ID " + orgId), nil
+			}
+			role.RoleIds = append(role.RoleIds, stringValue(astraRole.Id))
+			role.AstraRoleNames = append(role.AstraRoleNames, stringValue(astraRole.Name))
+		}
+		role.AstraRolesPinned = roleIdsOk || astraRoleNameOk
+	} else if createOperation {
+		return logical.ErrorResponse("please provide a policy, role_ids, role_id or astra_role_name argument"), nil
+	}
+
+	allowedActions, ok := d.GetOk("allowed_actions")
 	if ok {
-		role.RoleId = roleId.(string)
-	} else if !ok && createOperation {
-		return logical.ErrorResponse("please provide a role_id argument"), nil
+		role.AllowedActions = allowedActions.([]string)
+	}
+	allowedResources, ok := d.GetOk("allowed_resources")
+	if ok {
+		role.AllowedResources = allowedResources.([]string)
+	}
+
+	boundEntityIds, ok := d.GetOk("bound_entity_ids")
+	if ok {
+		role.BoundEntityIDs = boundEntityIds.([]string)
+	}
+	boundGroupIds, ok := d.GetOk("bound_group_ids")
+	if ok {
+		role.BoundGroupIDs = boundGroupIds.([]string)
+	}
+	boundEntityMetadata, ok, err := d.GetOkErr("bound_entity_metadata")
+	if err != nil {
+		return logical.ErrorResponse("error parsing bound_entity_metadata: " + err.Error()), nil
+	}
+	if ok {
+		role.BoundEntityMetadata = boundEntityMetadata.(map[string]string)
+	}
+	boundCidrs, ok := d.GetOk("bound_cidrs")
+	if ok {
+		valid, err := cidrutil.ValidateCIDRListSlice(boundCidrs.([]string))
+		if err != nil || !valid {
+			return logical.ErrorResponse("bound_cidrs must be a list of CIDR blocks"), nil
+		}
+		role.BoundCIDRs = boundCidrs.([]string)
+	}
+
+	readAccess, ok := d.GetOk("read_access")
+	if ok {
+		if !strutil.StrListContains(tokenReadAccessModes, readAccess.(string)) {
+			return logical.ErrorResponse("unrecognised read_access argument; valid values are 'owner', 'group' or 'any'"), nil
+		}
+		role.ReadAccess = readAccess.(string)
+	} else if createOperation {
+		role.ReadAccess = tokenReadAccessOwner
 	}
 
 	ttl, ok := d.GetOk("ttl")
@@ -192,8 +365,23 @@ func (b *datastaxAstraBackend) pathRoleWrite(ctx context.Context,
 		b.logger.Warn(fmt.Sprintf("The ttl value provided is greater than max_ttl (%s); setting ttl to %s", role.MaxTTL, role.TTL))
 	}
 
+	// Only touch Astra once the rest of the request is known to be valid
+	createdRoleId := ""
+	if policy != nil {
+		createdRoleId, err = b.upsertManagedRole(ctx, req.Storage, role, policy)
+		if err != nil {
+			return nil, toVaultError(err)
+		}
+	}
+
 	err = saveRole(ctx, req.Storage, role)
 	if err != nil {
+		// Don't leave behind an Astra role no Vault role knows about
+		if createdRoleId != "" {
+			if delErr := b.deleteManagedRole(context.Background(), req.Storage, role); delErr != nil {
+				b.logger.Error("Failed to clean up unsaved astra role " + createdRoleId + ": " + delErr.Error())
+			}
+		}
 		return nil, err
 	}
 
@@ -207,6 +395,37 @@ func (b *datastaxAstraBackend) pathRoleWrite(ctx context.Context,
 	return nil, nil
 }
 
+// findAstraRole looks up a role in an org's role catalogue by its ID, its name, or both. Names are
+//  matched case-insensitively, and must match a single role.
+func findAstraRole(astraRoles []dsAstraClient.Role, roleId, roleName string) (*dsAstraClient.Role, error) {
+	var found *dsAstraClient.Role
+	for i, astraRole := range astraRoles {
+		if roleId != "" {
+			if stringValue(astraRole.Id) != roleId {
+				continue
+			}
+			if roleName != "" && !strings.EqualFold(stringValue(astraRole.Name), roleName) {
+				return nil, errors.New("astra role " + roleId + " is named '" + stringValue(astraRole.Name) + "', not '" + roleName + "'")
+			}
+			return &astraRoles[i], nil
+		}
+		if strings.EqualFold(stringValue(astraRole.Name), roleName) {
+			if found != nil {
+				return nil, errors.New("more than one astra role is named '" + roleName + "'; use role_id instead")
+			}
+			found = &astraRoles[i]
+		}
+	}
+	if found == nil {
+		if roleId != "" {
+			return nil, errors.New("unable to find astra role with ID " + roleId)
+		}
+		return nil, errors.New("unable to find astra role named '" + roleName + "'")
+	}
+
+	return found, nil
+}
+
 // pathRolesDelete makes a request to Vault storage to delete a role
 func (b *datastaxAstraBackend) pathRoleDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	roleName, ok := d.GetOk("role_name")
@@ -218,7 +437,19 @@ func (b *datastaxAstraBackend) pathRoleDelete(ctx context.Context, req *logical.
 		return logical.ErrorResponse("please provide an org_id argument"), nil
 	}
 
-	err := req.Storage.Delete(ctx, roleStoragePath+orgId.(string)+roleStorageKeyDelimiter+roleName.(string))
+	role, err := readRole(ctx, req.Storage, roleName.(string), orgId.(string))
+	if err != nil {
+		return nil, err
+	}
+	if role != nil {
+		// Keep the Vault role until its Astra role is gone, so a failed delete can be retried
+		err = b.deleteManagedRole(ctx, req.Storage, role)
+		if err != nil {
+			return nil, toVaultError(err)
+		}
+	}
+
+	err = req.Storage.Delete(ctx, roleStoragePath+roleKey(orgId.(string), roleName.(string)))
 	if err != nil {
 		return nil, err
 	}
@@ -230,7 +461,7 @@ func (b *datastaxAstraBackend) pathRoleDelete(ctx context.Context, req *logical.
 func (b *datastaxAstraBackend) pathRoleExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
 	// The existence check determines whether the logical.Request.Operation value is Create or Update. In this case
 	//	we will skip the argument validation as it will be performed in the write path implementation.
-	obj, err := req.Storage.Get(ctx, roleStoragePath + data.Get("org_id").(string) + roleStorageKeyDelimiter + data.Get("role_name").(string))
+	obj, err := req.Storage.Get(ctx, roleStoragePath+roleKey(data.Get("org_id").(string), data.Get("role_name").(string)))
 	if err != nil {
 		return false, errors.New("error retrieving role from storage for existence check: " + err.Error())
 	}
@@ -243,6 +474,13 @@ const (
 	pathRoleHelpDescription = `
 This path allows you to read and write roles used to generate Astra tokens.
 You can configure a role to manage a token by setting the 'name' (role name)
-and 'org_id' (Astra organisation id) fields.
+and 'org_id' (Astra organisation id) fields, and the Astra roles its tokens get
+with 'role_ids', 'role_id' or 'astra_role_name'. The Astra roles must exist in
+the organisation when the role is written. Alternatively, set 'policy' to have
+the plugin create and own a custom Astra role with that policy document.
+Set 'allowed_actions' and 'allowed_resources' to let tokens be requested with
+an inline policy, which is narrowed down to what they allow. Set the 'bound_'
+fields to only issue the role's tokens to some Vault entities, identity groups
+or networks, and 'read_access' to decide who may read its tokens back.
 `
 )
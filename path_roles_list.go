@@ -8,12 +8,13 @@ import (
 
 const (
 	pathRoleListHelpSynopsis    = `List the existing roles in the given Astra organisation.`
-	pathRoleListHelpDescription = `Roles will be listed by their name.`
+	pathRoleListHelpDescription = `Roles will be listed by their name. Pass an org_id if the same name is used in several organisations.`
 )
 
 func pathRoleList(b *datastaxAstraBackend) *framework.Path {
 	return &framework.Path{
 		Pattern: "roles/?$",
+		Fields:  PathList(RolePathList).listFields(),
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ListOperation: &framework.PathOperation{
 				Callback: b.pathRolesList,
@@ -26,5 +27,5 @@ func pathRoleList(b *datastaxAstraBackend) *framework.Path {
 }
 
 func (b *datastaxAstraBackend) pathRolesList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	return b.pathObjectList(ctx, req, RolePathList)
+	return b.pathObjectList(ctx, req, d, RolePathList)
 }
@@ -0,0 +1,347 @@
+package datastax_astra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	roleNamePolicyUnderscore = "underscore"
+	roleNamePolicyHyphen     = "hyphen"
+)
+
+// roleNameInvalidChars matches the runs of characters in an Astra role name that can't be used in a Vault role name
+var roleNameInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// roleNamePolicies maps each name_policy to the separator that replaces invalid characters
+var roleNamePolicies = map[string]string{
+	roleNamePolicyUnderscore: "_",
+	roleNamePolicyHyphen:     "-",
+}
+
+// roleSyncResult records what syncing an org's roles changed, or would change on a dry run
+type roleSyncResult struct {
+	Created   []string
+	Updated   []string
+	Unchanged []string
+	Pruned    []string
+	Warnings  []string
+}
+
+func (r *roleSyncResult) ToResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"created":   r.Created,
+		"updated":   r.Updated,
+		"unchanged": r.Unchanged,
+		"pruned":    r.Pruned,
+	}
+}
+
+func pathRolesSync(b *datastaxAstraBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/sync",
+		Fields: map[string]*framework.FieldSchema{
+			"org_id": {
+				Type:        framework.TypeString,
+				Description: "UUID of the organization in Astra. If unset, the roles of every configured organization are synced.",
+			},
+			"dry_run": {
+				Type:        framework.TypeBool,
+				Description: "Report the changes a sync would make without writing or deleting any Vault roles.",
+			},
+			"name_policy": {
+				Type:        framework.TypeString,
+				Description: "How Astra role names are turned into Vault role names. 'underscore' (the default) replaces each run of characters other than letters and digits with '_', 'hyphen' with '-'. Names are always lowercased.",
+				Default:     roleNamePolicyUnderscore,
+			},
+			"prune": {
+				Type:        framework.TypeBool,
+				Description: "Delete the Vault roles of the organization whose Astra role no longer exists.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRolesSync,
+				Summary:  "Create or update a Vault role for every Astra role.",
+			},
+		},
+		HelpSynopsis:    pathRolesSyncHelpSynopsis,
+		HelpDescription: pathRolesSyncHelpDescription,
+	}
+}
+
+// sanitizeRoleName turns an Astra role name into a Vault role name using the given separator. Leading and
+// trailing separators are kept, so roles get the names update_roles.sh gave them.
+func sanitizeRoleName(name, separator string) string {
+	return roleNameInvalidChars.ReplaceAllString(strings.ToLower(name), separator)
+}
+
+func (b *datastaxAstraBackend) pathRolesSync(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	namePolicy := d.Get("name_policy").(string)
+	separator, ok := roleNamePolicies[namePolicy]
+	if !ok {
+		return logical.ErrorResponse("unrecognised name_policy argument; valid values are 'underscore' or 'hyphen'"), nil
+	}
+	dryRun := d.Get("dry_run").(bool)
+	prune := d.Get("prune").(bool)
+
+	var orgIds []string
+	orgIdRaw, ok := d.GetOk("org_id")
+	if ok {
+		config, err := readConfig(ctx, req.Storage, orgIdRaw.(string))
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			return logical.ErrorResponse("unable to find config for org ID " + orgIdRaw.(string)), nil
+		}
+		orgIds = []string{orgIdRaw.(string)}
+	} else {
+		var err error
+		orgIds, err = req.Storage.List(ctx, configStoragePath)
+		if err != nil {
+			return nil, errors.New("error loading config list: " + err.Error())
+		}
+		if len(orgIds) == 0 {
+			return logical.ErrorResponse("no configs found"), nil
+		}
+	}
+
+	resp := &logical.Response{Data: map[string]interface{}{}}
+	orgs := map[string]interface{}{}
+	for _, orgId := range orgIds {
+		result, err := b.syncRoles(ctx, req.Storage, orgId, separator, dryRun, prune)
+		if err != nil {
+			err = fmt.Errorf("error syncing roles for org ID %s: %w", orgId, err)
+			if len(orgIds) == 1 {
+				return nil, toVaultError(err)
+			}
+			// Don't let one unreachable org stop the others from being synced
+			b.logger.Error(err.Error())
+			resp.AddWarning(err.Error())
+			continue
+		}
+		for _, warning := range result.Warnings {
+			resp.AddWarning(warning)
+		}
+		orgs[orgId] = result.ToResponseData()
+	}
+	resp.Data["orgs"] = orgs
+	resp.Data["dry_run"] = dryRun
+
+	return resp, nil
+}
+
+// syncRoles creates a Vault role for every role in the org's Astra role catalogue. Vault roles are matched to
+// Astra roles by their sanitised name; a matched role only has its role_id updated, so its TTLs are kept.
+// Matched roles with several Astra roles, or whose Astra roles were given by name or with role_ids, are skipped.
+func (b *datastaxAstraBackend) syncRoles(ctx context.Context, s logical.Storage, orgId, separator string, dryRun, prune bool) (*roleSyncResult, error) {
+	client, err := b.getClient(ctx, s, orgId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+	astraRoles, err := client.ListRoles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing roles in astra: %w", err)
+	}
+	existing, err := readOrgRoles(ctx, s, orgId)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &roleSyncResult{
+		Created:   []string{},
+		Updated:   []string{},
+		Unchanged: []string{},
+		Pruned:    []string{},
+	}
+
+	// Sort so that the same role wins a name collision on every run
+	sort.Slice(astraRoles, func(i, j int) bool {
+		nameI, nameJ := stringValue(astraRoles[i].Name), stringValue(astraRoles[j].Name)
+		if nameI != nameJ {
+			return nameI < nameJ
+		}
+		return stringValue(astraRoles[i].Id) < stringValue(astraRoles[j].Id)
+	})
+	// Astra roles owned by a Vault role through its policy are already represented by that role,
+	//  and the temporary roles of tokens issued with an inline policy are not meant to be reused
+	temporary, err := readMinted(ctx, s, mintedRoleStoragePath, orgId)
+	if err != nil {
+		return nil, err
+	}
+	managed := map[string]bool{}
+	for roleId := range temporary {
+		managed[roleId] = true
+	}
+	for _, role := range existing {
+		if role.Policy != nil {
+			for _, roleId := range role.RoleIds {
+				managed[roleId] = true
+			}
+		}
+	}
+	astraNames := map[string]string{}
+	wanted := map[string]string{}
+	var roleNames []string
+	for _, astraRole := range astraRoles {
+		roleId, astraName := stringValue(astraRole.Id), stringValue(astraRole.Name)
+		if roleId == "" {
+			continue
+		}
+		astraNames[roleId] = astraName
+		if managed[roleId] {
+			continue
+		}
+		roleName := sanitizeRoleName(astraName, separator)
+		if roleName == "" {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("skipped astra role %q (%s) in org ID %s: it has no name", astraName, roleId, orgId))
+			continue
+		}
+		if otherId, ok := wanted[roleName]; ok {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("skipped astra role %q (%s) in org ID %s: role %s already maps to role name %s", astraName, roleId, orgId, otherId, roleName))
+			continue
+		}
+		if role, ok := existing[roleName]; ok && role.Policy != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("skipped astra role %q (%s) in org ID %s: role %s manages its own astra role", astraName, roleId, orgId, roleName))
+			continue
+		}
+		// Pointing such a role at a single Astra role would drop the others, or undo the one it was given
+		if role, ok := existing[roleName]; ok && (len(role.RoleIds) > 1 || role.AstraRolesPinned) {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("skipped astra role %q (%s) in org ID %s: role %s sets its own astra roles", astraName, roleId, orgId, roleName))
+			continue
+		}
+		wanted[roleName] = roleId
+		roleNames = append(roleNames, roleName)
+	}
+	sort.Strings(roleNames)
+
+	for _, roleName := range roleNames {
+		roleId := wanted[roleName]
+		role, ok := existing[roleName]
+		switch {
+		case !ok:
+			result.Created = append(result.Created, roleName)
+			role = &astraRoleEntry{
+				RoleName:   roleName,
+				OrgId:      orgId,
+				TTL:        defaultTtl,
+				MaxTTL:     defaultMaxTtl,
+				ReadAccess: tokenReadAccessOwner,
+			}
+		case !equalStrings(role.RoleIds, []string{roleId}) || !equalStrings(role.AstraRoleNames, []string{astraNames[roleId]}):
+			result.Updated = append(result.Updated, roleName)
+		default:
+			result.Unchanged = append(result.Unchanged, roleName)
+			continue
+		}
+		if dryRun {
+			continue
+		}
+		role.RoleIds = []string{roleId}
+		role.AstraRoleNames = []string{astraNames[roleId]}
+		err = saveRole(ctx, s, role)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if prune {
+		var prunable []string
+		for roleName, role := range existing {
+			// Roles matched by name were just pointed at an existing Astra role, even on a dry run.
+			//  Roles that manage their own Astra role recreate it when they are next written.
+			if _, synced := wanted[roleName]; synced || role.Policy != nil {
+				continue
+			}
+			// A role can no longer create tokens once any of its Astra roles is gone
+			for _, roleId := range role.RoleIds {
+				if _, exists := astraNames[roleId]; !exists {
+					prunable = append(prunable, roleName)
+					break
+				}
+			}
+		}
+		sort.Strings(prunable)
+		for _, roleName := range prunable {
+			result.Pruned = append(result.Pruned, roleName)
+			if dryRun {
+				continue
+			}
+			err = s.Delete(ctx, roleStoragePath+roleKey(orgId, roleName))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !dryRun {
+		b.logger.Info(fmt.Sprintf("Synced roles for org ID %s: %d created, %d updated, %d pruned",
+			orgId, len(result.Created), len(result.Updated), len(result.Pruned)))
+	}
+
+	return result, nil
+}
+
+// readOrgRoles reads every Vault role of an org, keyed by role name
+func readOrgRoles(ctx context.Context, s logical.Storage, orgId string) (map[string]*astraRoleEntry, error) {
+	keys, err := s.List(ctx, roleStoragePath)
+	if err != nil {
+		return nil, errors.New("error loading role list: " + err.Error())
+	}
+
+	roles := map[string]*astraRoleEntry{}
+	prefix := orgId + roleStorageKeyDelimiter
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		role, err := readRoleUsingKey(ctx, s, key)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil {
+			roles[role.RoleName] = role
+		}
+	}
+
+	return roles, nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+const (
+	pathRolesSyncHelpSynopsis    = `Creates or updates a Vault role for every role in an Astra organisation.`
+	pathRolesSyncHelpDescription = `
+This path reads the role catalogue of an Astra organisation, or of every configured
+organisation if no 'org_id' is given, and writes one Vault role per Astra role.
+Role names are derived from the Astra role names according to 'name_policy'.
+Existing Vault roles keep their ttl and max_ttl; only their role_id is updated.
+Set 'prune' to delete Vault roles whose Astra role no longer exists, and 'dry_run'
+to see the changes without making them.
+`
+)
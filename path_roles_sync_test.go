@@ -0,0 +1,153 @@
+package datastax_astra
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeRoleName(t *testing.T) {
+	require.Equal(t, "database_administrator", sanitizeRoleName("Database Administrator", "_"))
+	require.Equal(t, "-r-w-user-", sanitizeRoleName(" R/W  User ", "-"))
+	require.Equal(t, "_", sanitizeRoleName("***", "_"))
+	require.Equal(t, "", sanitizeRoleName("", "_"))
+}
+
+func TestRolesSync(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+
+	dbAdmin := server.AddRole("Database Administrator")
+	// Both map to r_w_user; the first by name wins
+	rwUser := server.AddRole("R W User")
+	server.AddRole("R/W User")
+
+	// An existing role with its own TTLs that now points at a stale role ID, and one whose Astra role is gone
+	require.NoError(t, saveRole(ctx, s, &astraRoleEntry{RoleName: "r_w_user", RoleIds: []string{"stale"}, OrgId: org_id, TTL: time.Hour, MaxTTL: 2 * time.Hour}))
+	require.NoError(t, saveRole(ctx, s, &astraRoleEntry{RoleName: "gone", RoleIds: []string{"deleted"}, OrgId: org_id, TTL: time.Hour, MaxTTL: 2 * time.Hour}))
+
+	sync := func(data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/sync",
+			Storage:   s,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "%v", resp)
+		return resp
+	}
+
+	resp := sync(map[string]interface{}{"org_id": org_id, "dry_run": true, "prune": true})
+	expected := map[string]interface{}{
+		"created":   []string{"database_administrator"},
+		"updated":   []string{"r_w_user"},
+		"unchanged": []string{},
+		"pruned":    []string{"gone"},
+	}
+	require.Equal(t, expected, resp.Data["orgs"].(map[string]interface{})[org_id])
+	require.Len(t, resp.Warnings, 1)
+	role, err := readRole(ctx, s, "r_w_user", org_id)
+	require.NoError(t, err)
+	require.Equal(t, []string{"stale"}, role.RoleIds)
+	role, err = readRole(ctx, s, "database_administrator", org_id)
+	require.NoError(t, err)
+	require.Nil(t, role)
+
+	sync(map[string]interface{}{"prune": true})
+	role, err = readRole(ctx, s, "r_w_user", org_id)
+	require.NoError(t, err)
+	require.Equal(t, []string{rwUser.ID}, role.RoleIds)
+	require.Equal(t, time.Hour, role.TTL)
+	require.Equal(t, 2*time.Hour, role.MaxTTL)
+	role, err = readRole(ctx, s, "database_administrator", org_id)
+	require.NoError(t, err)
+	require.Equal(t, []string{dbAdmin.ID}, role.RoleIds)
+	require.Equal(t, defaultTtl, role.TTL)
+	role, err = readRole(ctx, s, "gone", org_id)
+	require.NoError(t, err)
+	require.Nil(t, role)
+
+	resp = sync(map[string]interface{}{"org_id": org_id, "name_policy": "hyphen"})
+	require.Equal(t, []string{"database-administrator", "r-w-user"}, resp.Data["orgs"].(map[string]interface{})[org_id].(map[string]interface{})["created"])
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/sync",
+		Storage:   s,
+		Data:      map[string]interface{}{"name_policy": "camel"},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+}
+
+// TestRolesSyncChosenAstraRoles makes sure sync leaves alone the roles given several Astra roles, or
+// given their Astra role by name, even when their name matches another Astra role.
+func TestRolesSyncChosenAstraRoles(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+
+	readOnly := server.AddRole("Read Only User")
+	reportViewer := server.AddRole("Report Viewer")
+	for roleName, data := range map[string]map[string]interface{}{
+		"read_only_user": {"role_ids": readOnly.ID + "," + reportViewer.ID},
+		"report_viewer":  {"astra_role_name": "Read Only User"},
+	} {
+		data["org_id"] = org_id
+		data["role_name"] = roleName
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role",
+			Storage:   s,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/sync",
+		Storage:   s,
+		Data:      map[string]interface{}{"org_id": org_id, "prune": true},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "%v", resp)
+	expected := map[string]interface{}{
+		"created":   []string{},
+		"updated":   []string{},
+		"unchanged": []string{},
+		"pruned":    []string{},
+	}
+	require.Equal(t, expected, resp.Data["orgs"].(map[string]interface{})[org_id])
+	require.Len(t, resp.Warnings, 2)
+
+	role, err := readRole(ctx, s, "read_only_user", org_id)
+	require.NoError(t, err)
+	require.Equal(t, []string{readOnly.ID, reportViewer.ID}, role.RoleIds)
+	role, err = readRole(ctx, s, "report_viewer", org_id)
+	require.NoError(t, err)
+	require.Equal(t, []string{readOnly.ID}, role.RoleIds)
+}
+
+func TestRolesSyncSkipsTemporaryRoles(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+
+	server.AddRole("Database Administrator")
+	temporary := server.AddRole("vault-reader-0a1b2c3d")
+	require.NoError(t, recordMinted(ctx, s, mintedRoleStoragePath, temporary.ID, org_id))
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/sync",
+		Storage:   s,
+		Data:      map[string]interface{}{"org_id": org_id},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "%v", resp)
+	require.Equal(t, []string{"database_administrator"}, resp.Data["orgs"].(map[string]interface{})[org_id].(map[string]interface{})["created"])
+}
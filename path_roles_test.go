@@ -0,0 +1,284 @@
+package datastax_astra
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRoleAstraRoleName makes sure roles can reference Astra roles by name
+// and that only Astra roles that exist in the org can be referenced.
+func TestRoleAstraRoleName(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+	dbAdmin := server.AddRole("Database Administrator")
+
+	writeRole := func(data map[string]interface{}) *logical.Response {
+		data["org_id"] = org_id
+		data["role_name"] = "admin"
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role",
+			Storage:   s,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := writeRole(map[string]interface{}{"astra_role_name": "database administrator"})
+	require.Nil(t, resp)
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role",
+		Storage:   s,
+		Data:      map[string]interface{}{"org_id": org_id, "role_name": "admin"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, dbAdmin.ID, resp.Data["role_id"])
+	require.Equal(t, "Database Administrator", resp.Data["astra_role_name"])
+	require.Equal(t, []string{dbAdmin.ID}, resp.Data["role_ids"])
+	require.Equal(t, []string{"Database Administrator"}, resp.Data["astra_role_names"])
+
+	resp = writeRole(map[string]interface{}{"astra_role_name": "Database Admin"})
+	require.EqualError(t, resp.Error(), "unable to find astra role named 'Database Admin' in org ID "+org_id)
+
+	resp = writeRole(map[string]interface{}{"role_id": "unknownRoleId"})
+	require.EqualError(t, resp.Error(), "unable to find astra role with ID unknownRoleId in org ID "+org_id)
+
+	resp = writeRole(map[string]interface{}{"role_id": dbAdmin.ID, "astra_role_name": "Read Only User"})
+	require.True(t, resp.IsError())
+
+	resp = writeRole(map[string]interface{}{})
+	require.EqualError(t, resp.Error(), "please provide a policy, role_ids, role_id or astra_role_name argument")
+}
+
+// TestRoleMultipleAstraRoles makes sure a role can map to several Astra roles,
+// and that roles stored with a single role ID are still read.
+func TestRoleMultipleAstraRoles(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+	readOnly := server.AddRole("Read Only DB A")
+	readWrite := server.AddRole("Read Write DB B")
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"org_id":    org_id,
+			"role_name": "service",
+			"role_ids":  readOnly.ID + "," + readWrite.ID,
+		},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "org/token",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"org_id":       org_id,
+			"role_name":    "service",
+			"logical_name": "service",
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{readOnly.ID, readWrite.ID}, resp.Data["roles"])
+	token, _ := server.Token(resp.Data["clientId"].(string))
+	require.Equal(t, []string{readOnly.ID, readWrite.ID}, token.Roles)
+
+	// Entries written before role_ids existed hold a single role_id
+	entry := &logical.StorageEntry{
+		Key:   roleStoragePath + roleKey(org_id, "legacy"),
+		Value: []byte(`{"role_name":"legacy","role_id":"` + readOnly.ID + `","org_id":"` + org_id + `","ttl":3600000000000,"max_ttl":3600000000000}`),
+	}
+	require.NoError(t, s.Put(ctx, entry))
+	role, err := readRole(ctx, s, "legacy", org_id)
+	require.NoError(t, err)
+	require.Equal(t, []string{readOnly.ID}, role.RoleIds)
+}
+
+// TestRoleManagedAstraRole makes sure a role with a policy creates, updates
+// and deletes its own custom role in Astra.
+func TestRoleManagedAstraRole(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+
+	writeRole := func(data map[string]interface{}) *logical.Response {
+		data["org_id"] = org_id
+		data["role_name"] = "analytics"
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role",
+			Storage:   s,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := writeRole(map[string]interface{}{"policy": `{"actions": ["db-cql"]}`})
+	require.EqualError(t, resp.Error(), "policy must apply to at least one resource")
+	require.Empty(t, server.Roles())
+
+	resp = writeRole(map[string]interface{}{"policy": `{"actions": ["db-cql"], "resources": ["drn:astra:org:` + org_id + `:db:dbA"]}`})
+	require.Nil(t, resp)
+	role, err := readRole(ctx, s, "analytics", org_id)
+	require.NoError(t, err)
+	require.Len(t, role.RoleIds, 1)
+	astraRole, ok := server.Role(role.RoleIds[0])
+	require.True(t, ok)
+	require.Equal(t, "vault-analytics", astraRole.Name)
+	require.Equal(t, []string{"db-cql"}, astraRole.Policy.Actions)
+	require.Equal(t, "allow", astraRole.Policy.Effect)
+
+	resp = writeRole(map[string]interface{}{"policy": `{"actions": ["db-cql", "db-table-select"], "resources": ["drn:astra:org:` + org_id + `:db:dbA"]}`})
+	require.Nil(t, resp)
+	astraRole, _ = server.Role(role.RoleIds[0])
+	require.Equal(t, []string{"db-cql", "db-table-select"}, astraRole.Policy.Actions)
+	require.Len(t, server.Roles(), 1)
+
+	resp = writeRole(map[string]interface{}{"role_ids": "someRoleId"})
+	require.True(t, resp.IsError())
+
+	// Syncing doesn't turn the managed Astra role into a role of its own
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/sync",
+		Storage:   s,
+		Data:      map[string]interface{}{"org_id": org_id, "prune": true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{}, resp.Data["orgs"].(map[string]interface{})[org_id].(map[string]interface{})["created"])
+	require.Equal(t, []string{}, resp.Data["orgs"].(map[string]interface{})[org_id].(map[string]interface{})["pruned"])
+
+	// A managed role deleted in Astra is created again
+	server.DeleteRole(role.RoleIds[0])
+	resp = writeRole(map[string]interface{}{"policy": `{"actions": ["db-cql"], "resources": ["drn:astra:org:` + org_id + `:db:dbA"]}`})
+	require.Nil(t, resp)
+	role, err = readRole(ctx, s, "analytics", org_id)
+	require.NoError(t, err)
+	_, ok = server.Role(role.RoleIds[0])
+	require.True(t, ok)
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "role",
+		Storage:   s,
+		Data:      map[string]interface{}{"org_id": org_id, "role_name": "analytics"},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.Empty(t, server.Roles())
+}
+
+// TestRolePathParameters makes sure roles and tokens can be managed through paths that
+// carry the org ID and role name, and that role names containing ":" are listed intact.
+func TestRolePathParameters(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+	reporting := server.AddRole("Reporting")
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/" + org_id + "/reporting-daily",
+		Storage:   s,
+		Data:      map[string]interface{}{"role_id": reporting.ID},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	// The role is the same one the argument based path manages
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role",
+		Storage:   s,
+		Data:      map[string]interface{}{"org_id": org_id, "role_name": "reporting-daily"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{reporting.ID}, resp.Data["role_ids"])
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "creds/" + org_id + "/reporting-daily",
+		Storage:   s,
+		EntityID:  "entityA",
+		Data:      map[string]interface{}{"logical_name": "nightly"},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "%v", resp)
+	require.Equal(t, "reporting-daily", resp.Data["roleName"])
+	clientId := resp.Data["clientId"]
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/" + org_id + "/reporting-daily",
+		Storage:   s,
+		EntityID:  "entityA",
+		Data:      map[string]interface{}{"logical_name": "nightly"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, clientId, resp.Data["clientId"])
+
+	// A token read by client ID must belong to the org and role of the path, or policies scoped to it don't hold
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/" + org_id + "/billing",
+		Storage:   s,
+		Data:      map[string]interface{}{"role_id": server.AddRole("Billing").ID},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	for _, path := range []string{"creds/" + org_id + "/billing", "creds/otherOrgId/reporting-daily"} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+			Storage:   s,
+			EntityID:  "entityA",
+			Data:      map[string]interface{}{"client_id": clientId},
+		})
+		require.Error(t, err, path)
+		require.Nil(t, resp, path)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/" + org_id + "/reporting-daily",
+		Storage:   s,
+		EntityID:  "entityA",
+		Data:      map[string]interface{}{"client_id": clientId},
+	})
+	require.NoError(t, err)
+	require.Equal(t, clientId, resp.Data["clientId"])
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role",
+		Storage:   s,
+		Data:      map[string]interface{}{"org_id": org_id, "role_name": "team:reporting", "role_id": reporting.ID},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "roles/",
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"billing", "reporting-daily", "team:reporting"}, resp.Data["keys"])
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "roles/" + org_id + "/reporting-daily",
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	role, err := readRole(ctx, s, "reporting-daily", org_id)
+	require.NoError(t, err)
+	require.Nil(t, role)
+}
@@ -0,0 +1,433 @@
+package datastax_astra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	mintedTokenStoragePath = "minted-token/"
+	mintedRoleStoragePath  = "minted-role/"
+	// tidyMinAge keeps tidy away from tokens and roles created so recently that the request
+	//  creating them may still be in progress
+	tidyMinAge = 10 * time.Minute
+)
+
+// mintedEntry records a token or temporary role created in Astra by the plugin, so tidy can tell
+// what Vault created apart from what was created by hand once Vault no longer tracks it
+type mintedEntry struct {
+	OrgID     string    `json:"orgId"`
+	CreatedOn time.Time `json:"createdOn"`
+}
+
+// tidyOptions selects which kinds of drift tidy repairs rather than only reports
+type tidyOptions struct {
+	DeleteStaleEntries  bool
+	RevokeUntracked     bool
+	DeleteOrphanedRoles bool
+}
+
+// tidyResult records the drift tidy found between an org's tokens in Vault and in Astra
+type tidyResult struct {
+	StaleEntries    []string
+	UntrackedTokens []string
+	UnmanagedTokens []string
+	OrphanedRoles   []string
+	Warnings        []string
+}
+
+func (r *tidyResult) ToResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"stale_entries":    r.StaleEntries,
+		"untracked_tokens": r.UntrackedTokens,
+		"unmanaged_tokens": r.UnmanagedTokens,
+		"orphaned_roles":   r.OrphanedRoles,
+	}
+}
+
+func pathTidy(b *datastaxAstraBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tidy",
+		Fields: map[string]*framework.FieldSchema{
+			"org_id": {
+				Type:        framework.TypeString,
+				Description: "UUID of the organization in Astra. If unset, every configured organization is tidied.",
+			},
+			"delete_stale_entries": {
+				Type:        framework.TypeBool,
+				Description: "Delete the Vault entries of tokens that no longer exist in Astra, e.g. because they were deleted in the Astra UI.",
+			},
+			"revoke_untracked": {
+				Type:        framework.TypeBool,
+				Description: "Revoke the Astra tokens Vault created but no longer tracks, e.g. because a request failed half way. Tokens created outside of Vault are only reported.",
+			},
+			"delete_orphaned_roles": {
+				Type:        framework.TypeBool,
+				Description: "Delete the temporary Astra roles Vault created for tokens requested with an inline policy that no longer exist.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathTidy,
+				Summary:  "Reconcile the tokens stored in Vault with those in Astra.",
+			},
+		},
+		HelpSynopsis:    pathTidyHelpSynopsis,
+		HelpDescription: pathTidyHelpDescription,
+	}
+}
+
+// recordMinted records a token or temporary role just created in Astra, so that tidy can revoke it
+// should Vault lose track of it
+func recordMinted(ctx context.Context, s logical.Storage, prefix, id, orgId string) error {
+	entry, err := logical.StorageEntryJSON(prefix+id, &mintedEntry{OrgID: orgId, CreatedOn: time.Now()})
+	if err != nil {
+		return err
+	}
+	err = s.Put(ctx, entry)
+	if err != nil {
+		return errors.New("error recording " + strings.TrimSuffix(prefix, "/") + " " + id + ": " + err.Error())
+	}
+
+	return nil
+}
+
+// forgetMinted deletes the record of a token or temporary role that has been deleted from Astra
+func forgetMinted(ctx context.Context, s logical.Storage, prefix, id string) error {
+	err := s.Delete(ctx, prefix+id)
+	if err != nil {
+		return errors.New("error deleting " + strings.TrimSuffix(prefix, "/") + " " + id + ": " + err.Error())
+	}
+
+	return nil
+}
+
+// readMinted reads the records under a minted prefix that belong to an org, keyed by token client ID or role ID
+func readMinted(ctx context.Context, s logical.Storage, prefix, orgId string) (map[string]*mintedEntry, error) {
+	ids, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, errors.New("error loading " + strings.TrimSuffix(prefix, "/") + " list: " + err.Error())
+	}
+
+	minted := map[string]*mintedEntry{}
+	for _, id := range ids {
+		entry, err := s.Get(ctx, prefix+id)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		record := &mintedEntry{}
+		err = entry.DecodeJSON(record)
+		if err != nil {
+			return nil, err
+		}
+		if record.OrgID == orgId {
+			minted[id] = record
+		}
+	}
+
+	return minted, nil
+}
+
+func (b *datastaxAstraBackend) pathTidy(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	options := tidyOptions{
+		DeleteStaleEntries:  d.Get("delete_stale_entries").(bool),
+		RevokeUntracked:     d.Get("revoke_untracked").(bool),
+		DeleteOrphanedRoles: d.Get("delete_orphaned_roles").(bool),
+	}
+
+	var orgIds []string
+	orgIdRaw, ok := d.GetOk("org_id")
+	if ok {
+		config, err := readConfig(ctx, req.Storage, orgIdRaw.(string))
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			return logical.ErrorResponse("unable to find config for org ID " + orgIdRaw.(string)), nil
+		}
+		orgIds = []string{orgIdRaw.(string)}
+	} else {
+		var err error
+		orgIds, err = req.Storage.List(ctx, configStoragePath)
+		if err != nil {
+			return nil, errors.New("error loading config list: " + err.Error())
+		}
+		if len(orgIds) == 0 {
+			return logical.ErrorResponse("no configs found"), nil
+		}
+	}
+
+	resp := &logical.Response{Data: map[string]interface{}{}}
+	orgs := map[string]interface{}{}
+	for _, orgId := range orgIds {
+		result, err := b.tidyOrg(ctx, req.Storage, orgId, options)
+		if err != nil {
+			err = fmt.Errorf("error tidying org ID %s: %w", orgId, err)
+			if len(orgIds) == 1 {
+				return nil, toVaultError(err)
+			}
+			// Don't let one unreachable org stop the others from being tidied
+			b.logger.Error(err.Error())
+			resp.AddWarning(err.Error())
+			continue
+		}
+		for _, warning := range result.Warnings {
+			resp.AddWarning(warning)
+		}
+		orgs[orgId] = result.ToResponseData()
+	}
+	resp.Data["orgs"] = orgs
+	resp.Data["delete_stale_entries"] = options.DeleteStaleEntries
+	resp.Data["revoke_untracked"] = options.RevokeUntracked
+	resp.Data["delete_orphaned_roles"] = options.DeleteOrphanedRoles
+
+	return resp, nil
+}
+
+// tidyOrg compares the tokens and temporary roles of an org in Astra with those Vault tracks, and repairs
+// the drift the options select:
+// - stale entries are tokens Vault tracks that no longer exist in Astra;
+// - untracked tokens exist in Astra and were created by Vault, which no longer tracks them;
+// - unmanaged tokens exist in Astra but were not created by Vault, or before it recorded what it created;
+// - orphaned roles are temporary roles created by Vault whose token no longer exists.
+func (b *datastaxAstraBackend) tidyOrg(ctx context.Context, s logical.Storage, orgId string, options tidyOptions) (*tidyResult, error) {
+	config, err := readConfig(ctx, s, orgId)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("unable to find config for org ID " + orgId)
+	}
+	client, err := b.getClient(ctx, s, orgId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+	astraTokens, err := listTokensInAstra(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("error listing tokens in astra: %w", err)
+	}
+	astraRoles, err := client.ListRoles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing roles in astra: %w", err)
+	}
+
+	result := &tidyResult{
+		StaleEntries:    []string{},
+		UntrackedTokens: []string{},
+		UnmanagedTokens: []string{},
+		OrphanedRoles:   []string{},
+	}
+	now := time.Now()
+	inAstra := map[string]bool{}
+	for _, astraToken := range astraTokens {
+		inAstra[astraToken.ClientID] = true
+	}
+
+	// Everything Vault tracks for the org: its tokens, the previous versions of rotated tokens and its root token
+	tracked := map[string]bool{}
+	referencedRoles := map[string]bool{}
+	if rootClientId, err := clientIdFromToken(config.AstraToken); err == nil {
+		tracked[rootClientId] = true
+	}
+	tokenIds, err := s.List(ctx, "token/")
+	if err != nil {
+		return nil, errors.New("failed to get token list: " + err.Error())
+	}
+	for _, tokenId := range tokenIds {
+		token, err := readToken(ctx, s, tokenId)
+		if err != nil {
+			return nil, err
+		}
+		if token == nil || token.OrgID != orgId {
+			continue
+		}
+		tracked[token.ClientID] = true
+		if inAstra[token.ClientID] {
+			if token.TemporaryRoleId != "" {
+				referencedRoles[token.TemporaryRoleId] = true
+			}
+			continue
+		}
+		// Astra was listed before the tokens were read, so a token created in between looks stale
+		if generatedOn, err := time.Parse(time.RFC3339, token.GeneratedOn); err == nil && now.Sub(generatedOn) < tidyMinAge {
+			if token.TemporaryRoleId != "" {
+				referencedRoles[token.TemporaryRoleId] = true
+			}
+			continue
+		}
+		result.StaleEntries = append(result.StaleEntries, token.ClientID)
+		if !options.DeleteStaleEntries {
+			if token.TemporaryRoleId != "" {
+				referencedRoles[token.TemporaryRoleId] = true
+			}
+			continue
+		}
+		err = deleteTokenFromStorage(ctx, s, tokenId)
+		if err != nil {
+			return nil, err
+		}
+		b.logger.Info("Tidy deleted entry of token '" + token.ClientID + "' which no longer exists in Astra")
+	}
+	retiredIds, err := s.List(ctx, retiredTokenStoragePath)
+	if err != nil {
+		return nil, errors.New("error loading retired token list: " + err.Error())
+	}
+	for _, clientId := range retiredIds {
+		retired, err := readRetiredToken(ctx, s, clientId)
+		if err != nil {
+			return nil, err
+		}
+		if retired == nil || retired.OrgID != orgId {
+			continue
+		}
+		tracked[clientId] = true
+		if !inAstra[clientId] && options.DeleteStaleEntries {
+			err = s.Delete(ctx, retiredTokenStoragePath+clientId)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	mintedTokens, err := readMinted(ctx, s, mintedTokenStoragePath, orgId)
+	if err != nil {
+		return nil, err
+	}
+	for _, astraToken := range astraTokens {
+		clientId := astraToken.ClientID
+		if tracked[clientId] {
+			continue
+		}
+		record, minted := mintedTokens[clientId]
+		if !minted {
+			result.UnmanagedTokens = append(result.UnmanagedTokens, clientId)
+			continue
+		}
+		if now.Sub(record.CreatedOn) < tidyMinAge {
+			continue
+		}
+		result.UntrackedTokens = append(result.UntrackedTokens, clientId)
+		if !options.RevokeUntracked {
+			continue
+		}
+		err = deleteTokenFromAstra(ctx, client, clientId)
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("unable to revoke untracked token %s in org ID %s: %s", clientId, orgId, err.Error()))
+			continue
+		}
+		inAstra[clientId] = false
+		b.logger.Info("Tidy revoked token '" + clientId + "' which Vault created but no longer tracks")
+	}
+	// Records of tokens that are gone from Astra are no longer needed
+	for clientId := range mintedTokens {
+		if !inAstra[clientId] {
+			err = s.Delete(ctx, mintedTokenStoragePath+clientId)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	mintedRoles, err := readMinted(ctx, s, mintedRoleStoragePath, orgId)
+	if err != nil {
+		return nil, err
+	}
+	roleExists := map[string]bool{}
+	for _, astraRole := range astraRoles {
+		roleId := stringValue(astraRole.Id)
+		roleExists[roleId] = true
+		record, minted := mintedRoles[roleId]
+		if !minted || referencedRoles[roleId] || now.Sub(record.CreatedOn) < tidyMinAge {
+			continue
+		}
+		result.OrphanedRoles = append(result.OrphanedRoles, roleId)
+		if !options.DeleteOrphanedRoles {
+			continue
+		}
+		err = client.DeleteCustomRole(ctx, roleId)
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("unable to delete orphaned role %s in org ID %s: %s", roleId, orgId, err.Error()))
+			continue
+		}
+		roleExists[roleId] = false
+		b.logger.Info("Tidy deleted temporary astra role " + roleId + " whose token no longer exists")
+	}
+	for roleId := range mintedRoles {
+		if !roleExists[roleId] {
+			err = s.Delete(ctx, mintedRoleStoragePath+roleId)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Strings(result.StaleEntries)
+	sort.Strings(result.UntrackedTokens)
+	sort.Strings(result.UnmanagedTokens)
+	sort.Strings(result.OrphanedRoles)
+	b.logger.Info(fmt.Sprintf("Tidied org ID %s: %d stale entries, %d untracked tokens, %d unmanaged tokens, %d orphaned roles",
+		orgId, len(result.StaleEntries), len(result.UntrackedTokens), len(result.UnmanagedTokens), len(result.OrphanedRoles)))
+
+	return result, nil
+}
+
+// tidyDueOrgs tidies the orgs whose tidy_interval has passed since they were last tidied by this node
+func (b *datastaxAstraBackend) tidyDueOrgs(ctx context.Context, s logical.Storage, now time.Time) error {
+	orgIds, err := s.List(ctx, configStoragePath)
+	if err != nil {
+		return errors.New("error loading config list: " + err.Error())
+	}
+
+	for _, orgId := range orgIds {
+		config, err := readConfig(ctx, s, orgId)
+		if err != nil {
+			b.logger.Error("Unable to check scheduled tidy for org ID " + orgId + ": " + err.Error())
+			continue
+		}
+		if config == nil || config.TidyInterval <= 0 {
+			continue
+		}
+		if lastTidy, ok := b.lastTidy[orgId]; ok && now.Before(lastTidy.Add(config.TidyInterval)) {
+			continue
+		}
+		b.lastTidy[orgId] = now
+
+		result, err := b.tidyOrg(ctx, s, orgId, tidyOptions{
+			DeleteStaleEntries:  config.TidyDeleteStaleEntries,
+			RevokeUntracked:     config.TidyRevokeUntracked,
+			DeleteOrphanedRoles: config.TidyDeleteOrphanedRoles,
+		})
+		if err != nil {
+			b.logger.Error("Scheduled tidy of org ID " + orgId + " failed: " + err.Error())
+			continue
+		}
+		for _, warning := range result.Warnings {
+			b.logger.Warn(warning)
+		}
+	}
+
+	return nil
+}
+
+const (
+	pathTidyHelpSynopsis    = `Reconciles the tokens stored in Vault with the tokens in Astra.`
+	pathTidyHelpDescription = `
+This path lists the tokens of an Astra organisation, or of every configured
+organisation if no 'org_id' is given, and compares them with the tokens Vault
+tracks. It reports the Vault entries of tokens that no longer exist in Astra,
+the Astra tokens Vault created but no longer tracks, the Astra tokens created
+outside of Vault, and the temporary roles of tokens that no longer exist.
+Set 'delete_stale_entries', 'revoke_untracked' and 'delete_orphaned_roles' to
+repair the drift, and 'tidy_interval' on the config to tidy on a schedule.
+`
+)
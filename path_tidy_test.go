@@ -0,0 +1,138 @@
+package datastax_astra
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTidy makes sure tidy reports the drift between Vault and Astra, and only repairs it when asked to.
+func TestTidy(t *testing.T) {
+	b, s, server := newConfiguredTestBackend(t)
+	ctx := context.Background()
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/" + org_id + "/app",
+		Storage:   s,
+		Data:      map[string]interface{}{"role_id": server.AddRole("Read Only User").ID, "read_access": "any"},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	createToken := func(logicalName string) string {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "creds/" + org_id + "/app",
+			Storage:   s,
+			Data:      map[string]interface{}{"logical_name": logicalName},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "%v", resp)
+		return resp.Data["clientId"].(string)
+	}
+	// Records of what Vault created are only acted on once they are old enough
+	backdate := func(prefix, id string) {
+		entry, err := logical.StorageEntryJSON(prefix+id, &mintedEntry{OrgID: org_id, CreatedOn: time.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+		require.NoError(t, s.Put(ctx, entry))
+	}
+
+	tracked := createToken("web")
+	stale := createToken("deleted-in-astra")
+	server.DeleteToken(stale)
+	roleEntry, err := readRole(ctx, s, "app", org_id)
+	require.NoError(t, err)
+	staleToken, err := readToken(ctx, s, calculateTokenId(roleEntry, "deleted-in-astra"))
+	require.NoError(t, err)
+	staleToken.GeneratedOn = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	require.NoError(t, saveToken(ctx, s, staleToken, calculateTokenId(roleEntry, "deleted-in-astra")))
+	// A token created while tidy runs isn't in its listing of Astra yet, so recent tokens are never stale
+	recentStale := createToken("created-during-tidy")
+	server.DeleteToken(recentStale)
+	untracked := createToken("lost")
+	require.NoError(t, deleteTokenFromStorage(ctx, s, calculateTokenId(roleEntry, "lost")))
+	backdate(mintedTokenStoragePath, untracked)
+	recent := createToken("recent")
+	require.NoError(t, deleteTokenFromStorage(ctx, s, calculateTokenId(roleEntry, "recent")))
+	unmanaged := server.AddToken("adminRoleId").ClientID
+	orphaned := server.AddRole("temporary").ID
+	backdate(mintedRoleStoragePath, orphaned)
+
+	tidy := func(data map[string]interface{}) map[string]interface{} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "tidy",
+			Storage:   s,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "%v", resp)
+		return resp.Data["orgs"].(map[string]interface{})[org_id].(map[string]interface{})
+	}
+
+	// Without options tidy only reports
+	result := tidy(map[string]interface{}{"org_id": org_id})
+	require.Equal(t, []string{stale}, result["stale_entries"])
+	require.Equal(t, []string{untracked}, result["untracked_tokens"])
+	require.Equal(t, []string{unmanaged}, result["unmanaged_tokens"])
+	require.Equal(t, []string{orphaned}, result["orphaned_roles"])
+	_, ok := server.Token(untracked)
+	require.True(t, ok)
+	token, err := readToken(ctx, s, calculateTokenId(roleEntry, "deleted-in-astra"))
+	require.NoError(t, err)
+	require.NotNil(t, token)
+
+	result = tidy(map[string]interface{}{
+		"delete_stale_entries":  true,
+		"revoke_untracked":      true,
+		"delete_orphaned_roles": true,
+	})
+	require.Equal(t, []string{stale}, result["stale_entries"])
+	token, err = readToken(ctx, s, calculateTokenId(roleEntry, "deleted-in-astra"))
+	require.NoError(t, err)
+	require.Nil(t, token)
+	token, err = readToken(ctx, s, calculateTokenId(roleEntry, "created-during-tidy"))
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	_, ok = server.Token(untracked)
+	require.False(t, ok)
+	_, ok = server.Role(orphaned)
+	require.False(t, ok)
+	// Tokens Vault tracks, didn't create or created too recently are left alone
+	for _, clientId := range []string{tracked, unmanaged, recent} {
+		_, ok = server.Token(clientId)
+		require.True(t, ok, clientId)
+	}
+
+	result = tidy(map[string]interface{}{"org_id": org_id})
+	require.Empty(t, result["stale_entries"])
+	require.Empty(t, result["untracked_tokens"])
+	require.Empty(t, result["orphaned_roles"])
+}
+
+// TestScheduledTidy makes sure the periodic function tidies an org once its tidy_interval has passed.
+func TestScheduledTidy(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	server := configureTestBackend(t, b, s, map[string]interface{}{
+		"tidy_interval":              "1h",
+		"tidy_delete_orphaned_roles": true,
+	})
+	orphaned := server.AddRole("temporary").ID
+	entry, err := logical.StorageEntryJSON(mintedRoleStoragePath+orphaned, &mintedEntry{OrgID: org_id, CreatedOn: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, entry))
+
+	now := time.Now()
+	b.lastTidy[org_id] = now
+	require.NoError(t, b.tidyDueOrgs(ctx, s, now.Add(time.Minute)))
+	_, ok := server.Role(orphaned)
+	require.True(t, ok)
+
+	require.NoError(t, b.tidyDueOrgs(ctx, s, now.Add(time.Hour)))
+	_, ok = server.Role(orphaned)
+	require.False(t, ok)
+}